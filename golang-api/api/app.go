@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/graphql-go/graphql"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/sync/singleflight"
+)
+
+// App holds the state shared by the HTTP handlers and middleware.
+type App struct {
+	config  Config
+	store   ItemStore
+	logger  *log.Logger
+	now     func() time.Time
+	schemas map[string]*jsonschema.Schema
+	events  *broadcaster
+	ready   cachedHealth
+	graphql graphql.Schema
+
+	// listeners is set by main as the HTTP and gRPC servers bind.
+	listeners listenerState
+
+	metrics     Metrics
+	inFlight    atomic.Int64
+	maintenance atomic.Bool
+
+	// cors and rateLimit hold the middleware that SIGHUP reconfigures, and
+	// live is the configuration last applied.
+	cors      swappable
+	rateLimit swappable
+	live      atomic.Pointer[Config]
+
+	// otel is nil unless OTEL_EXPORTER_OTLP_ENDPOINT is set.
+	otel *otelMetrics
+
+	// concurrency is nil unless MAX_CONCURRENT is set.
+	concurrency *concurrencyLimiter
+
+	// listCache is nil unless LIST_CACHE_TTL is set.
+	listCache *listCache
+
+	// importer fetches the arrays POST /items/import-url creates items from.
+	importer *urlImporter
+
+	// webhooks holds the subscriptions item events are posted to.
+	webhooks *webhookRegistry
+	// reminders notifies about items falling due when DUE_SOON_LEAD is set.
+	reminders *dueNotifier
+
+	// history holds the labeled snapshots GET /items/diff compares.
+	history snapshotHistory
+
+	// listQueries lets concurrent identical list queries share one snapshot
+	// and encoding.
+	listQueries singleflight.Group
+}
+
+func NewApp(cfg Config, store ItemStore, logger *log.Logger, now func() time.Time) (*App, error) {
+	schemas, err := loadSchemas()
+	if err != nil {
+		return nil, fmt.Errorf("loading request schemas: %w", err)
+	}
+
+	a := &App{
+		config:  cfg,
+		store:   store,
+		logger:  logger,
+		now:     now,
+		schemas: schemas,
+		events:  newBroadcaster(cfg.SSESendTimeout, cfg.SSEIdleTimeout, logger),
+		history: snapshotHistory{retain: cfg.SnapshotRetain},
+		ready:   cachedHealth{ttl: cfg.HealthCacheTTL, now: now},
+
+		importer: newURLImporter(cfg.ImportTimeout, int64(cfg.ImportMaxBytes), cfg.ImportAllowPrivate,
+			newCircuitBreaker(cfg.BreakerFailures, cfg.BreakerCooldown, now)),
+	}
+	a.live.Store(&cfg)
+	sender := newURLImporter(cfg.WebhookTimeout, 0, cfg.WebhookAllowPrivate,
+		newCircuitBreaker(cfg.BreakerFailures, cfg.BreakerCooldown, now))
+	if a.webhooks, err = newWebhookRegistry(cfg.WebhooksPath, sender, logger, now); err != nil {
+		return nil, fmt.Errorf("loading WEBHOOKS_PATH: %w", err)
+	}
+	a.events.onPublish(a.webhooks.enqueue)
+	if cfg.DueSoonLead > 0 {
+		a.reminders = newDueNotifier(store, cfg.DueSoonLead, cfg.DueSoonWebhook,
+			newCircuitBreaker(cfg.BreakerFailures, cfg.BreakerCooldown, now), now)
+	}
+	if cfg.MaxConcurrent > 0 {
+		a.concurrency = newConcurrencyLimiter(cfg.MaxConcurrent, cfg.MaxConcurrentWait)
+	}
+	if cfg.ListCacheTTL > 0 {
+		a.listCache = newListCache(store, cfg.ListCacheTTL, now)
+	}
+	if cfg.OTLPEndpoint != "" {
+		if a.otel, err = newOTelMetrics(context.Background(), store); err != nil {
+			return nil, fmt.Errorf("setting up OpenTelemetry metrics: %w", err)
+		}
+	}
+
+	if a.graphql, err = a.graphqlSchema(); err != nil {
+		return nil, fmt.Errorf("building GraphQL schema: %w", err)
+	}
+
+	a.ready.register("http", a.listeners.check("http"))
+	if cfg.GRPCPort != "" {
+		a.ready.register("grpc", a.listeners.check("grpc"))
+	}
+	a.ready.register("maintenance", func() healthResult {
+		if a.maintenance.Load() {
+			return healthResult{Status: degraded, Detail: "writes are disabled"}
+		}
+		return healthResult{Status: healthy}
+	})
+	if cfg.ReadyMaxItems > 0 {
+		a.ready.register("items", itemCountCheck(store, cfg.ReadyMaxItems))
+	}
+	if cfg.ReadyMaxHeapMB > 0 {
+		a.ready.register("memory", heapCheck(cfg.ReadyMaxHeapMB))
+	}
+	a.ready.register("import-url", a.importer.breaker.check)
+	a.ready.register("webhooks", a.webhooks.sender.breaker.check)
+	if a.reminders != nil && cfg.DueSoonWebhook != "" {
+		a.ready.register("due-soon-webhook", a.reminders.breaker.check)
+	}
+	return a, nil
+}
+
+// namedMiddleware gives a middleware a name so the assembled stack can be
+// logged.
+type namedMiddleware struct {
+	name    string
+	handler func(http.Handler) http.Handler
+}
+
+// middlewares declares the global middleware stack, outermost first. Optional
+// entries are only present when configured, except the ones SIGHUP can turn on
+// later, which sit in swappable slots.
+func (a *App) middlewares(mux *chi.Mux) []namedMiddleware {
+	cfg := a.config
+
+	// The recoverer wraps everything, so a panic in any middleware still gets
+	// a 500. In-flight tracking and metrics come next so they count every
+	// request, including ones rejected further in; metrics and the logger
+	// record a panicking request with the 500 the recoverer answers.
+	stack := []namedMiddleware{
+		{"recoverer", middleware.Recoverer},
+		{"in-flight", a.trackInFlight},
+		{"metrics", a.metrics.middleware},
+		{"logger", logAccess},
+		{"request-id", middleware.RequestID},
+		// Resolved once, for the request logger and the rate limiter.
+		{"client-ip", clientIPs(cfg.TrustedProxies)},
+		// Needs the request id, and sits ahead of anything that might log.
+		{"request-logger", requestLogger},
+	}
+
+	if cfg.ResponseTimeHeader {
+		stack = append(stack, namedMiddleware{"response-time", responseTime})
+	}
+
+	if a.otel != nil {
+		a.logger.Printf("Exporting OpenTelemetry metrics to %s", redactURL(cfg.OTLPEndpoint))
+		stack = append(stack, namedMiddleware{"otel-metrics", a.otel.middleware})
+	}
+
+	// /items/ routes like /items, either directly or after a redirect.
+	if cfg.TrailingSlash == "redirect" {
+		stack = append(stack, namedMiddleware{"trailing-slash", middleware.RedirectSlashes})
+	} else {
+		stack = append(stack, namedMiddleware{"trailing-slash", middleware.StripSlashes})
+	}
+
+	// CORS precedes anything that can reject a request, so even errors reach
+	// the browser with CORS headers and preflights are never throttled.
+	a.cors.set(corsMiddleware(cfg))
+	stack = append(stack, namedMiddleware{"cors", a.cors.middleware})
+
+	if len(cfg.DisabledEndpoints) > 0 {
+		a.logger.Printf("Disabled endpoints: %s", strings.Join(cfg.DisabledEndpoints, ", "))
+		stack = append(stack, namedMiddleware{"disabled-endpoints", disableEndpoints(mux, cfg.DisabledEndpoints)})
+	}
+
+	// The concurrency cap sits inside in-flight tracking and metrics, so
+	// requests it turns away are still counted.
+	if a.concurrency != nil {
+		a.logger.Printf("Limiting to %d concurrent requests (wait up to %s)", cfg.MaxConcurrent, cfg.MaxConcurrentWait)
+		stack = append(stack, namedMiddleware{"concurrency", a.concurrency.middleware})
+	}
+
+	stack = append(stack, namedMiddleware{"cache-control", cacheControl(cfg.CachePolicies)})
+
+	// Compression wraps everything that writes a body, rejections included.
+	stack = append(stack, namedMiddleware{"compress", compress})
+
+	// Inside compression, so rewritten errors are compressed too, and ahead of
+	// the middleware that rejects requests, so their errors are rewritten.
+	stack = append(stack, namedMiddleware{"problem", problemDetails})
+
+	stack = append(stack, namedMiddleware{"maintenance", a.rejectWritesDuringMaintenance})
+
+	a.rateLimit.set(a.rateLimitMiddleware(cfg))
+	stack = append(stack, namedMiddleware{"rate-limit", a.rateLimit.middleware})
+
+	// Timeouts wrap chaos so an injected delay can run out the deadline.
+	if hasTimeouts(cfg.RequestTimeout, cfg.RouteTimeouts) {
+		stack = append(stack, namedMiddleware{"timeout", routeTimeouts(mux, cfg.RequestTimeout, cfg.RouteTimeouts)})
+	}
+
+	// Chaos is innermost so injected delays and failures look like they come
+	// from the handlers themselves.
+	if cfg.ChaosDelay > 0 || cfg.ChaosErrorRate > 0 {
+		a.logger.Printf("Chaos enabled: delay up to %s, error rate %.2f, seed %d", cfg.ChaosDelay, cfg.ChaosErrorRate, cfg.ChaosSeed)
+		stack = append(stack, namedMiddleware{"chaos", newChaos(cfg.ChaosDelay, cfg.ChaosErrorRate, cfg.ChaosSeed).middleware})
+	}
+	return stack
+}
+
+// routes builds the router, failing if DISABLED_ENDPOINTS names a route it
+// doesn't have.
+func (a *App) routes() (http.Handler, error) {
+	r := chi.NewRouter()
+
+	stack := a.middlewares(r)
+	names := make([]string, len(stack))
+	for i, m := range stack {
+		r.Use(m.handler)
+		names[i] = m.name
+	}
+	a.logger.Printf("Middleware: %s", strings.Join(names, " > "))
+
+	r.Get("/", a.index)
+	r.Get("/ping", a.ping)
+	r.Get("/health", a.health)
+	r.Get("/metrics", a.serveMetrics)
+	r.Get("/health/ready", a.readiness)
+
+	if apiKey := a.config.AdminAPIKey; apiKey != "" {
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(requireAPIKey(apiKey))
+			r.Post("/maintenance", a.setMaintenance)
+			r.Post("/reset", a.resetItems)
+			r.Post("/compact", a.compactStore)
+		})
+	} else {
+		a.logger.Printf("ADMIN_API_KEY not set; admin endpoints are disabled")
+	}
+
+	if a.config.Debug {
+		r.Get("/debug/config", a.debugConfig)
+		r.Get("/debug/info", a.debugInfo)
+	}
+
+	r.Get("/graphql", a.graphiql)
+	r.Post("/graphql", a.serveGraphQL)
+
+	r.Get("/tags", a.listTags)
+
+	r.Get("/items", a.listItems)
+	r.Get("/items.ics", a.itemsCalendar)
+	r.Get("/items/schema", a.itemJSONSchema)
+	r.Get("/items/stats", a.itemStats)
+	r.Get("/items/count", a.countItems)
+	r.Get("/items/metrics", a.itemMetrics)
+	r.Get("/items/today", a.todayItems)
+	r.Get("/items/activity", a.itemActivity)
+	r.Get("/items/random", a.randomItem)
+	r.Get("/items/oldest-pending", a.oldestPendingItem)
+	r.Get("/items/stream", a.streamItems)
+	r.Get("/items/events", a.itemEvents)
+	r.Get("/items/snapshots", a.listSnapshots)
+	r.Post("/items/snapshots", a.createSnapshot)
+	r.Get("/items/diff", a.diffItems)
+	r.Get("/items/slug/{slug}", a.getItemBySlug)
+	r.Get("/items/{id}", a.getItem)
+	r.Get("/items/{id}/events", a.watchItem)
+	r.Get("/items/{id}/completed-at", a.itemCompletedAt)
+	r.Get("/items/{id}/blockers", a.itemBlockers)
+	r.Post("/items", a.createItem)
+	r.Post("/items/bulk", a.bulkCreateItems)
+	r.Post("/items/validate", a.validateItems)
+	r.Post("/items/bulk-update", a.bulkUpdateItems)
+	r.Post("/items/batch", a.batchItems)
+	r.Post("/items/tag", a.bulkTagItems)
+	r.Post("/items/import-url", a.importItemsFromURL)
+	r.Put("/items/{id}", a.replaceItem)
+	r.Patch("/items/{id}", a.patchItem)
+	r.Post("/items/{id}/complete", a.completeItem)
+	r.Post("/items/{id}/uncomplete", a.uncompleteItem)
+	r.Delete("/items/{id}", a.deleteItem)
+
+	r.Get("/webhooks", a.listWebhooks)
+	r.Post("/webhooks", a.createWebhook)
+	r.Delete("/webhooks/{id}", a.deleteWebhook)
+
+	if err := checkEndpoints(r, a.config.DisabledEndpoints); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMain(m *testing.M) {
+	// Keep a line per request out of the test and benchmark output.
+	accessLog.Logger = log.New(io.Discard, "", 0)
+	os.Exit(m.Run())
+}
+
+// newTestApp builds the app on a seeded store, with env applied over the
+// defaults, and returns it with its routed handler.
+func newTestApp(t testing.TB, env map[string]string) (*App, http.Handler) {
+	t.Helper()
+	store := NewStore()
+	store.Reset(seedItems)
+	return newTestAppOn(t, env, store)
+}
+
+// newTestAppOn is newTestApp for a store of the caller's.
+func newTestAppOn(t testing.TB, env map[string]string, store ItemStore) (*App, http.Handler) {
+	t.Helper()
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	app, err := NewApp(cfg, store, log.New(io.Discard, "", 0), time.Now)
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	handler, err := app.routes()
+	if err != nil {
+		t.Fatalf("routes: %v", err)
+	}
+	return app, handler
+}
+
+// newStoreOf returns a store holding n items, every third one completed.
+func newStoreOf(t testing.TB, n int) *Store {
+	t.Helper()
+	store := NewStore()
+	for i := range n {
+		in := ItemInput{Name: fmt.Sprintf("Item %d", n-i), Completed: i%3 == 0, Tags: []string{"bench"}}
+		if _, err := store.Create(in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+// do sends a request to h and returns the recorded response.
+func do(h http.Handler, method, target, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"MAX_CONCURRENT": "10"})
+
+	var names []string
+	for _, m := range app.middlewares(chi.NewRouter()) {
+		names = append(names, m.name)
+	}
+	if want := []string{"recoverer", "in-flight", "metrics", "logger"}; !slices.Equal(names[:len(want)], want) {
+		t.Fatalf("stack starts %v, want %v", names[:len(want)], want)
+	}
+
+	// Each pair must appear in this order, outermost first.
+	for _, pair := range [][2]string{
+		{"request-id", "request-logger"},
+		{"client-ip", "rate-limit"},
+		{"cors", "concurrency"},
+		{"cors", "rate-limit"},
+		{"compress", "problem"},
+		{"problem", "maintenance"},
+		{"problem", "rate-limit"},
+	} {
+		outer, inner := slices.Index(names, pair[0]), slices.Index(names, pair[1])
+		if outer < 0 || inner < 0 || outer > inner {
+			t.Errorf("%s must wrap %s: %v", pair[0], pair[1], names)
+		}
+	}
+}
+
+func TestPanicIsRecoveredAndCounted(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+
+	var h http.Handler = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})
+	stack := app.middlewares(chi.NewRouter())
+	for i := len(stack) - 1; i >= 0; i-- {
+		h = stack[i].handler(h)
+	}
+
+	rec := do(h, http.MethodGet, "/items", "", "")
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	if got := app.metrics.statuses[http.StatusInternalServerError].Load(); got != 1 {
+		t.Errorf("metrics counted %d 500s, want 1", got)
+	}
+	if got := app.inFlight.Load(); got != 0 {
+		t.Errorf("%d requests still in flight after the panic", got)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// batchOp is one operation of POST /items/batch. Its id is an item's ID or,
+// as "$N", the item that operation N of the same batch created.
+type batchOp struct {
+	Op              string          `json:"op"`
+	ID              json.RawMessage `json:"id"`
+	Name            *string         `json:"name"`
+	Completed       *bool           `json:"completed"`
+	EstimateMinutes *int            `json:"estimateMinutes"`
+	Tags            *[]string       `json:"tags"`
+	DueDate         *time.Time      `json:"dueDate"`
+	ExpiresAt       *time.Time      `json:"expiresAt"`
+	BlockedBy       *[]int          `json:"blockedBy"`
+}
+
+func (op batchOp) input() ItemInput {
+	in := ItemInput{Name: *op.Name, DueDate: op.DueDate, ExpiresAt: op.ExpiresAt}
+	if op.Completed != nil {
+		in.Completed = *op.Completed
+	}
+	if op.EstimateMinutes != nil {
+		in.EstimateMinutes = *op.EstimateMinutes
+	}
+	if op.Tags != nil {
+		in.Tags = *op.Tags
+	}
+	if op.BlockedBy != nil {
+		in.BlockedBy = *op.BlockedBy
+	}
+	return in
+}
+
+func (op batchOp) update() ItemUpdate {
+	return ItemUpdate{
+		Name:            op.Name,
+		Completed:       op.Completed,
+		EstimateMinutes: op.EstimateMinutes,
+		Tags:            op.Tags,
+		DueDate:         op.DueDate,
+		ExpiresAt:       op.ExpiresAt,
+		BlockedBy:       op.BlockedBy,
+	}
+}
+
+// ref returns the ID op names or, with an ID of zero, the index of the
+// operation whose item it refers to. The schema has already checked the form.
+func (op batchOp) ref() (id, index int) {
+	if json.Unmarshal(op.ID, &id) == nil {
+		return id, 0
+	}
+	var s string
+	json.Unmarshal(op.ID, &s)
+	index, _ = strconv.Atoi(strings.TrimPrefix(s, "$"))
+	return 0, index
+}
+
+type batchResult struct {
+	Index  int           `json:"index"`
+	Op     string        `json:"op"`
+	Status int           `json:"status"`
+	ID     int           `json:"id"`
+	Item   *ItemResponse `json:"item,omitempty"`
+}
+
+// batchItems applies a list of creates, updates and deletes in order, in one
+// transaction: if any of them fails, none of them happened. Events go out only
+// once the batch has committed.
+func (a *App) batchItems(w http.ResponseWriter, r *http.Request) {
+	var ops []batchOp
+	if !decodeValidated(w, r, a.schemas["item-batch"], &ops) {
+		return
+	}
+	for i, op := range ops {
+		if op.Op == "create" {
+			continue
+		}
+		if id, index := op.ref(); id == 0 && (index >= i || ops[index].Op != "create") {
+			http.Error(w, fmt.Sprintf("op %d: id $%d must refer to an earlier create", i, index), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	results := make([]batchResult, len(ops))
+	changed := make([]bool, len(ops))
+	err := a.storeFor(r.Context()).WithTx(func(tx StoreTx) error {
+		for i, op := range ops {
+			results[i] = batchResult{Index: i, Op: op.Op}
+			if op.Op == "create" {
+				item, err := tx.Create(op.input())
+				if err != nil {
+					return fmt.Errorf("op %d: %w", i, err)
+				}
+				response := newItemResponse(item)
+				results[i].Status, results[i].ID, results[i].Item = http.StatusCreated, item.ID, &response
+				continue
+			}
+
+			id, index := op.ref()
+			if id == 0 {
+				id = results[index].ID
+			}
+			results[i].ID = id
+			switch op.Op {
+			case "update":
+				item, ok, err := tx.Update(id, op.update())
+				if err != nil {
+					return fmt.Errorf("op %d: %w", i, err)
+				}
+				response := newItemResponse(item)
+				results[i].Status, results[i].Item = http.StatusOK, &response
+				changed[i] = ok
+			case "delete":
+				if _, ok := tx.Delete(id); !ok {
+					return fmt.Errorf("op %d: %w", i, ErrNotFound)
+				}
+				results[i].Status = http.StatusNoContent
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), storeErrorStatus(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"results": results})
+	for i, result := range results {
+		switch result.Op {
+		case "create":
+			a.events.publish("created", *result.Item)
+		case "update":
+			if changed[i] {
+				a.events.publish("updated", *result.Item)
+			}
+		case "delete":
+			a.events.publish("deleted", map[string]int{"id": result.ID})
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errCircuitOpen = errors.New("too many recent failures; try again later")
+
+// circuitBreaker fails calls to a target fast once it has failed threshold
+// times in a row, so a dependency that is down isn't waited on again and
+// again. After cooldown one trial call is let through: it closes the circuit
+// if it succeeds and opens it for another cooldown if it fails. Targets are
+// tracked apart, so one failing webhook host doesn't cut off the rest.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu sync.Mutex
+	// failing holds the targets whose last call failed; a success drops them.
+	failing map[string]*breakerTarget
+}
+
+type breakerTarget struct {
+	failures int
+	openedAt time.Time
+	// trial is set while the call after a cooldown is under way.
+	trial bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, now func() time.Time) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: now, failing: make(map[string]*breakerTarget)}
+}
+
+// allow reports whether a call to target may go ahead, returning
+// errCircuitOpen when it may not. Every allowed call must be followed by done.
+func (b *circuitBreaker) allow(target string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.failing[target]
+	if t == nil || t.failures < b.threshold {
+		return nil
+	}
+	if t.trial || b.now().Sub(t.openedAt) < b.cooldown {
+		return errCircuitOpen
+	}
+	t.trial = true
+	return nil
+}
+
+// done records how an allowed call to target went.
+func (b *circuitBreaker) done(target string, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		delete(b.failing, target)
+		return
+	}
+	t := b.failing[target]
+	if t == nil {
+		t = &breakerTarget{}
+		b.failing[target] = t
+	}
+	t.failures++
+	t.trial = false
+	if t.failures >= b.threshold {
+		t.openedAt = b.now()
+	}
+}
+
+// open lists the targets whose circuit is open, in order.
+func (b *circuitBreaker) open() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var targets []string
+	for target, t := range b.failing {
+		if t.failures >= b.threshold {
+			targets = append(targets, target)
+		}
+	}
+	slices.Sort(targets)
+	return targets
+}
+
+// check reports the breaker on /health/ready: degraded while any circuit is
+// open, since only the calls to that target are failing.
+func (b *circuitBreaker) check() healthResult {
+	targets := b.open()
+	if len(targets) == 0 {
+		return healthResult{Status: healthy}
+	}
+	return healthResult{Status: degraded, Detail: fmt.Sprintf("circuit open for %s", strings.Join(targets, ", "))}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(2, time.Minute, func() time.Time { return now })
+
+	fail := func(target string) {
+		t.Helper()
+		if err := b.allow(target); err != nil {
+			t.Fatalf("allow(%q) = %v, want nil", target, err)
+		}
+		b.done(target, true)
+	}
+
+	fail("a")
+	fail("a")
+	if err := b.allow("a"); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("allow after %d failures = %v, want errCircuitOpen", 2, err)
+	}
+	if err := b.allow("b"); err != nil {
+		t.Fatalf("allow(b) = %v; another target's circuit opened", err)
+	}
+	b.done("b", false)
+	if got := b.open(); !slices.Equal(got, []string{"a"}) {
+		t.Fatalf("open() = %v, want [a]", got)
+	}
+	if got := b.check(); got.Status != degraded || !strings.Contains(got.Detail, "a") {
+		t.Fatalf("check() = %+v, want degraded naming a", got)
+	}
+
+	// After the cooldown a single trial goes through; a failure reopens.
+	now = now.Add(time.Minute)
+	fail("a")
+	if err := b.allow("a"); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("allow after failed trial = %v, want errCircuitOpen", err)
+	}
+
+	// Only one trial at a time, and a success closes the circuit.
+	now = now.Add(time.Minute)
+	if err := b.allow("a"); err != nil {
+		t.Fatalf("trial allow = %v, want nil", err)
+	}
+	if err := b.allow("a"); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("second allow during trial = %v, want errCircuitOpen", err)
+	}
+	b.done("a", false)
+	if err := b.allow("a"); err != nil {
+		t.Fatalf("allow after successful trial = %v, want nil", err)
+	}
+	b.done("a", false)
+	if got := b.check(); got.Status != healthy {
+		t.Fatalf("check() = %+v, want healthy", got)
+	}
+}
+
+func TestImportOpensCircuit(t *testing.T) {
+	calls := 0
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer remote.Close()
+
+	_, h := newTestApp(t, map[string]string{
+		"IMPORT_ALLOW_PRIVATE": "true",
+		"BREAKER_FAILURES":     "3",
+		"BREAKER_COOLDOWN":     "1h",
+	})
+	// One import makes all its attempts, which opens the circuit.
+	body := `{"url":"` + remote.URL + `"}`
+	if rec := do(h, http.MethodPost, "/items/import-url", "application/json", body); rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502: %s", rec.Code, rec.Body)
+	}
+	failed := calls
+	if failed != importAttempts {
+		t.Fatalf("remote called %d times, want %d", failed, importAttempts)
+	}
+
+	rec := do(h, http.MethodPost, "/items/import-url", "application/json", body)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status with the circuit open = %d, want 503: %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Retry-After") != "3600" {
+		t.Errorf("Retry-After = %q, want 3600", rec.Header().Get("Retry-After"))
+	}
+	if calls != failed {
+		t.Errorf("remote called %d times with the circuit open", calls-failed)
+	}
+
+	rec = do(h, http.MethodGet, "/health/ready", "", "")
+	if !strings.Contains(rec.Body.String(), `"import-url":{"status":"degraded"`) {
+		t.Errorf("ready did not report the open circuit: %s", rec.Body)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultCachePolicy keeps item data out of shared and browser caches, so no
+// client ever reads a stale item.
+const defaultCachePolicy = "no-store"
+
+// defaultCachePolicies are the Cache-Control headers of routes that allow
+// caching, keyed by chi route pattern. CACHE_POLICIES adds to and overrides
+// them.
+var defaultCachePolicies = map[string]string{
+	"/":        "public, max-age=300",
+	"/graphql": "public, max-age=300",
+	// The schema only changes with a new build or TIME_FORMAT.
+	"/items/schema": "public, max-age=300",
+	// Feeds support conditional requests, so caches can revalidate them.
+	"/items.ics": "no-cache",
+}
+
+// parseCachePolicies reads PATTERN=VALUE entries separated by semicolons,
+// such as "/tags=max-age=30;/items/stats=no-cache", over the defaults.
+func parseCachePolicies(v string) (map[string]string, error) {
+	policies := make(map[string]string, len(defaultCachePolicies))
+	for pattern, policy := range defaultCachePolicies {
+		policies[pattern] = policy
+	}
+	for _, entry := range strings.Split(v, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		pattern, policy, ok := strings.Cut(entry, "=")
+		pattern, policy = strings.TrimSpace(pattern), strings.TrimSpace(policy)
+		if !ok || !strings.HasPrefix(pattern, "/") || policy == "" {
+			return nil, fmt.Errorf("invalid CACHE_POLICIES entry %q: must be a route pattern=Cache-Control value", entry)
+		}
+		policies[pattern] = policy
+	}
+	return policies, nil
+}
+
+// cacheControl sets Cache-Control from the policy of the route that served a
+// GET, unless the handler set one itself; other methods get no-store. Routing
+// happens after the middleware runs, so the header is added once the response
+// starts.
+func cacheControl(policies map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&cacheControlWriter{ResponseWriter: w, r: r, policies: policies}, r)
+		})
+	}
+}
+
+type cacheControlWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	policies    map[string]string
+	wroteHeader bool
+}
+
+func (c *cacheControlWriter) WriteHeader(status int) {
+	if !c.wroteHeader && status >= http.StatusOK {
+		c.wroteHeader = true
+		if h := c.Header(); h.Get("Cache-Control") == "" {
+			policy := defaultCachePolicy
+			// Policies are for reads; a POST response with a max-age could
+			// otherwise be cached.
+			if c.r.Method == http.MethodGet || c.r.Method == http.MethodHead {
+				if p, ok := c.policies[chi.RouteContext(c.r.Context()).RoutePattern()]; ok {
+					policy = p
+				}
+			}
+			h.Set("Cache-Control", policy)
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *cacheControlWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *cacheControlWriter) Flush() {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection.
+func (c *cacheControlWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// parseTrustedProxies reads CIDRs such as 10.0.0.0/8, accepting a bare address
+// as a network of one.
+func parseTrustedProxies(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, v := range list {
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(v)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: must be a CIDR such as 10.0.0.0/8", v)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// resolveClientIP finds the client behind r. X-Forwarded-For is only believed
+// when the direct peer is a trusted proxy, and then only as far as the chain of
+// trusted hops goes: walking it from the right, the first address that isn't a
+// trusted proxy is the client. Anything further left was supplied by that
+// client and could be forged.
+func resolveClientIP(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	peer := remoteAddr(r)
+	if !peer.IsValid() || !isTrusted(peer, trusted) {
+		return peer
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	ip := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// The chain can't be followed past a malformed hop, so the last
+			// trusted proxy is the best we know.
+			break
+		}
+		ip = addr.Unmap()
+		if !isTrusted(ip, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+func remoteAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, _ := netip.ParseAddr(host)
+	return addr.Unmap()
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIPs resolves each request's client address once, for clientIP.
+func clientIPs(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientIPKey{}, resolveClientIP(r, trusted))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// clientIP is the address rate limiting and logging attribute r to. Outside
+// the clientIPs middleware it is the direct peer, or RemoteAddr verbatim when
+// that isn't an IP address.
+func clientIP(r *http.Request) string {
+	addr, ok := r.Context().Value(clientIPKey{}).(netip.Addr)
+	if !ok {
+		addr = remoteAddr(r)
+	}
+	if !addr.IsValid() {
+		return r.RemoteAddr
+	}
+	return addr.String()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, peer string
+		forwarded  []string
+		want       string
+	}{
+		{"untrusted peer spoofing", "203.0.113.5:4000", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"untrusted peer, no header", "203.0.113.5:4000", nil, "203.0.113.5"},
+		{"trusted peer", "10.1.2.3:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted chain", "10.1.2.3:4000", []string{"198.51.100.1, 192.0.2.7, 10.9.9.9"}, "198.51.100.1"},
+		{"forged hop left of the client", "10.1.2.3:4000", []string{"6.6.6.6, 198.51.100.1"}, "198.51.100.1"},
+		{"repeated headers", "10.1.2.3:4000", []string{"6.6.6.6", "198.51.100.1"}, "198.51.100.1"},
+		{"malformed hop", "10.1.2.3:4000", []string{"198.51.100.1, not-an-ip"}, "10.1.2.3"},
+		{"trusted peer, no header", "192.0.2.7:4000", nil, "192.0.2.7"},
+		{"mapped IPv4 peer", "[::ffff:10.1.2.3]:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/items", nil)
+			r.RemoteAddr = tt.peer
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := resolveClientIP(r, trusted).String(); got != tt.want {
+				t.Errorf("resolveClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesRejectsGarbage(t *testing.T) {
+	if _, err := parseTrustedProxies([]string{"10.0.0.0/8", "proxy.internal"}); err == nil {
+		t.Error("parseTrustedProxies accepted a host name")
+	}
+}
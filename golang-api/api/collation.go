@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// defaultCollation orders names for requests that name no supported locale.
+var defaultCollation = language.English
+
+// collationMatcher maps requested locales onto those collate supports,
+// falling back to defaultCollation when nothing matches.
+var collationMatcher = language.NewMatcher(append([]language.Tag{defaultCollation}, collate.Supported()...))
+
+// collators pools collators per locale tag, since building one is costly and
+// a Collator can't be shared between goroutines.
+var collators sync.Map
+
+// collationLocale picks the locale to sort names in: the locale query
+// parameter if given, else the request's Accept-Language, else the default.
+func collationLocale(r *http.Request) (language.Tag, error) {
+	if v := r.URL.Query().Get("locale"); v != "" {
+		tag, err := language.Parse(v)
+		if err != nil {
+			return language.Und, fmt.Errorf("locale %q is not a valid language tag", v)
+		}
+		matched, _, _ := collationMatcher.Match(tag)
+		return matched, nil
+	}
+	if tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language")); err == nil && len(tags) > 0 {
+		matched, _, _ := collationMatcher.Match(tags...)
+		return matched, nil
+	}
+	return defaultCollation, nil
+}
+
+// withCollator runs fn with a collator for locale, taken from and returned to
+// the pool.
+func withCollator(locale language.Tag, fn func(c *collate.Collator)) {
+	key := locale.String()
+	v, ok := collators.Load(key)
+	if !ok {
+		v, _ = collators.LoadOrStore(key, &sync.Pool{
+			New: func() any { return collate.New(locale) },
+		})
+	}
+	pool := v.(*sync.Pool)
+	c := pool.Get().(*collate.Collator)
+	defer pool.Put(c)
+	fn(c)
+}
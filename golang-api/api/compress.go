@@ -0,0 +1,151 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	encodingGzip     = "gzip"
+	encodingIdentity = "identity"
+)
+
+// negotiateEncoding picks the response coding from an Accept-Encoding header
+// by q-value, preferring gzip on a tie. Identity is acceptable unless the
+// header rules it out with identity;q=0 or *;q=0. It reports false when
+// neither coding is acceptable.
+func negotiateEncoding(header string) (string, bool) {
+	if strings.TrimSpace(header) == "" {
+		return encodingIdentity, true
+	}
+
+	q := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		weight := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+
+		switch coding {
+		case "":
+		case "*":
+			wildcard = weight
+		case "x-gzip":
+			q[encodingGzip] = weight
+		default:
+			q[coding] = weight
+		}
+	}
+
+	weight := func(coding string, fallback float64) float64 {
+		if w, ok := q[coding]; ok {
+			return w
+		}
+		if wildcard >= 0 {
+			return wildcard
+		}
+		return fallback
+	}
+	gzipQ := weight(encodingGzip, 0)
+	identityQ := weight(encodingIdentity, 1)
+
+	switch {
+	case gzipQ > 0 && gzipQ >= identityQ:
+		return encodingGzip, true
+	case identityQ > 0:
+		return encodingIdentity, true
+	}
+	return "", false
+}
+
+// compress gzips responses for clients that prefer it and answers 406 when
+// the client accepts neither gzip nor an uncompressed body.
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding, ok := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if !ok {
+			http.Error(w, "Not Acceptable: supported encodings are gzip and identity", http.StatusNotAcceptable)
+			return
+		}
+		if encoding == encodingIdentity || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// gzipResponseWriter compresses the body once the status is known to allow
+// one. Handlers set Content-Length for the uncompressed body, so it is
+// dropped.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if status < http.StatusOK {
+		// Informational responses precede the real one.
+		g.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	if status != http.StatusNoContent && status != http.StatusNotModified {
+		h := g.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", encodingGzip)
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz == nil {
+		return g.ResponseWriter.Write(b)
+	}
+	return g.gz.Write(b)
+}
+
+// Flush pushes buffered compressed data to the client, keeping streaming
+// endpoints such as /items/events live.
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection.
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+func (g *gzipResponseWriter) close() {
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
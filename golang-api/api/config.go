@@ -0,0 +1,618 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultShutdownTimeout = 10 * time.Second
+	defaultSSESendTimeout  = 5 * time.Second
+	defaultSSEIdleTimeout  = time.Minute
+)
+
+// defaultMaxHeaderBytes matches net/http's own default.
+const defaultMaxHeaderBytes = 1 << 20
+
+const (
+	defaultImportTimeout  = 10 * time.Second
+	defaultImportMaxBytes = 1 << 20
+	defaultWebhookTimeout = 10 * time.Second
+
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// defaultMaxJSONDepth and defaultMaxJSONElements are far beyond anything the
+// item schemas accept.
+const (
+	defaultMaxJSONDepth    = 32
+	defaultMaxJSONElements = 1000
+	defaultMaxBodyBytes    = 1 << 20
+)
+
+const (
+	defaultMaxTags      = 20
+	defaultMaxTagLength = 50
+)
+
+const defaultSlugLength = 60
+
+const defaultWALCompactAfter = 1000
+
+const (
+	defaultPageSize = 50
+	defaultMaxPage  = 100
+)
+
+const (
+	defaultSnapshotRetain  = 20
+	defaultDueSoonInterval = time.Minute
+
+	defaultExpirySweepInterval = time.Minute
+)
+
+// Config is the service configuration, read from the environment once at
+// startup.
+type Config struct {
+	// Debug exposes GET /debug/config.
+	Debug    bool
+	LogLevel slog.Level
+	// SelfTest runs the in-process self-test instead of serving.
+	SelfTest bool
+	// ResponseTimeHeader adds X-Response-Time-Ms to every response.
+	ResponseTimeHeader bool
+
+	Port            string
+	TrailingSlash   string // how /items/ is handled: strip or redirect
+	MaxHeaderBytes  int
+	GRPCPort        string // starts the gRPC ItemService when set
+	OTLPEndpoint    string // exports OpenTelemetry metrics when set
+	ShutdownTimeout time.Duration
+	AdminAPIKey     string
+	TimeFormat      string
+
+	MaxItems    int
+	UniqueScope UniqueScope
+	// IDFormat is how new items are numbered: sequential or time.
+	IDFormat string
+
+	MaxTags      int
+	MaxTagLength int
+
+	// SlugLength caps how many characters of a name go into its slug.
+	SlugLength int
+
+	// WALPath, when set, is the write-ahead log the store is restored from
+	// and appends every change to. WALSync syncs it after each write.
+	WALPath         string
+	WALCompactAfter int
+	WALSync         bool
+
+	// MaxBodyBytes caps how much of a request body is read at all;
+	// MaxJSONDepth and MaxJSONElements then bound it before it is decoded.
+	MaxBodyBytes    int
+	MaxJSONDepth    int
+	MaxJSONElements int
+
+	PageSize    int
+	MaxPageSize int
+
+	// MaxConcurrent caps requests handled at once; zero disables it. Requests
+	// over the cap wait up to MaxConcurrentWait before being refused.
+	MaxConcurrent     int
+	MaxConcurrentWait time.Duration
+
+	// RateLimit is in requests per minute per client; zero disables it.
+	RateLimit      int
+	RateLimitBurst int
+	RateLimitMode  string
+
+	ChaosDelay     time.Duration
+	ChaosErrorRate float64
+	ChaosSeed      int64
+
+	// ReadyMaxItems and ReadyMaxHeapMB make /health/ready fail beyond them;
+	// zero disables the check.
+	ReadyMaxItems  int
+	ReadyMaxHeapMB int
+	// HealthCacheTTL lets readiness probes reuse a result this recent; zero
+	// runs the checks on every probe.
+	HealthCacheTTL time.Duration
+
+	// CORSOrigins enables CORS when set; "*" allows any origin.
+	CORSOrigins     []string
+	CORSMethods     []string
+	CORSHeaders     []string
+	CORSCredentials bool
+
+	// TrustedProxies are the peers whose X-Forwarded-For names the client;
+	// requests from anyone else are attributed to their own address.
+	TrustedProxies []netip.Prefix
+
+	// CachePolicies maps route patterns to their Cache-Control header; other
+	// routes get no-store.
+	CachePolicies map[string]string
+
+	// DefaultSort orders GET /items when the request gives no sort, in the
+	// form parseSortSpec reads. Empty lists items by ID.
+	DefaultSort string
+
+	// DisabledEndpoints are routes answered with 404, as route patterns
+	// optionally after a method; routes checks they exist.
+	DisabledEndpoints []string
+
+	// ImportTimeout and ImportMaxBytes bound each fetch of POST
+	// /items/import-url. ImportAllowPrivate lets it fetch plain http and
+	// private addresses, for local demos.
+	ImportTimeout      time.Duration
+	ImportMaxBytes     int
+	ImportAllowPrivate bool
+
+	// WebhooksPath is the file webhook subscriptions are kept in; unset, they
+	// last until the server stops. WebhookTimeout bounds each delivery
+	// attempt, and WebhookAllowPrivate works like ImportAllowPrivate.
+	WebhooksPath        string
+	WebhookTimeout      time.Duration
+	WebhookAllowPrivate bool
+
+	// BreakerFailures is how many calls in a row to an outbound host (import
+	// URLs, webhooks, the due-soon webhook) may fail before its circuit opens;
+	// BreakerCooldown is how long it then stays open.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+
+	// StrictQuery makes GET /items reject query parameters it doesn't know,
+	// rather than ignoring a misspelled filter.
+	StrictQuery bool
+
+	// RequestTimeout bounds every request when set, except on routes that
+	// RouteTimeouts gives their own limit.
+	RequestTimeout time.Duration
+	RouteTimeouts  map[string]time.Duration
+
+	// SlowThreshold is how long a store call may take before it is logged.
+	SlowThreshold time.Duration
+
+	// ListCacheTTL serves GET /items from a background-refreshed snapshot
+	// when set; zero disables the cache.
+	ListCacheTTL time.Duration
+
+	// SnapshotInterval takes a labeled snapshot for GET /items/diff that
+	// often when set; SnapshotRetain caps how many are kept.
+	SnapshotInterval time.Duration
+	SnapshotRetain   int
+
+	// ExpirySweepInterval is how often expired items are purged.
+	ExpirySweepInterval time.Duration
+
+	// DueSoonLead enables reminders for pending items due within it, checked
+	// every DueSoonInterval and posted to DueSoonWebhook when that is set.
+	DueSoonLead     time.Duration
+	DueSoonInterval time.Duration
+	DueSoonWebhook  string
+
+	// SSESendTimeout is how long an event subscriber may block before it is
+	// dropped.
+	SSESendTimeout time.Duration
+	// SSEIdleTimeout is how long an event subscriber may go without a
+	// successful write, heartbeats included, before it is reaped.
+	SSEIdleTimeout time.Duration
+}
+
+func loadConfig() (Config, error) {
+	cfg := Config{
+		Port:          os.Getenv("PORT"),
+		GRPCPort:      os.Getenv("GRPC_PORT"),
+		OTLPEndpoint:  os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		AdminAPIKey:   os.Getenv("ADMIN_API_KEY"),
+		TimeFormat:    timeFormatRFC3339,
+		RateLimitMode: "enforce",
+		CORSOrigins:   listEnv("CORS_ORIGINS", nil),
+		CORSMethods:   listEnv("CORS_METHODS", []string{"GET", "POST", "PUT", "PATCH", "DELETE"}),
+		CORSHeaders:   listEnv("CORS_HEADERS", []string{"Content-Type", "X-API-Key"}),
+		ChaosSeed:     time.Now().UnixNano(),
+	}
+	if cfg.Port == "" {
+		cfg.Port = "8080"
+	}
+
+	var err error
+	if err = checkPort("PORT", cfg.Port); err != nil {
+		return Config{}, err
+	}
+	if cfg.GRPCPort != "" {
+		if err = checkPort("GRPC_PORT", cfg.GRPCPort); err != nil {
+			return Config{}, err
+		}
+	}
+	if cfg.ShutdownTimeout, err = positiveDurationEnv("SHUTDOWN_TIMEOUT", defaultShutdownTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.SSESendTimeout, err = positiveDurationEnv("SSE_SEND_TIMEOUT", defaultSSESendTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.SSEIdleTimeout, err = positiveDurationEnv("SSE_IDLE_TIMEOUT", defaultSSEIdleTimeout); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.MaxHeaderBytes, err = positiveIntEnv("MAX_HEADER_BYTES", defaultMaxHeaderBytes); err != nil {
+		return Config{}, err
+	}
+
+	switch v := os.Getenv("TRAILING_SLASH"); v {
+	case "", "strip":
+		cfg.TrailingSlash = "strip"
+	case "redirect":
+		cfg.TrailingSlash = v
+	default:
+		return Config{}, fmt.Errorf("invalid TRAILING_SLASH %q: must be strip or redirect", v)
+	}
+
+	switch v := os.Getenv("TIME_FORMAT"); v {
+	case "", timeFormatRFC3339:
+	case timeFormatUnix:
+		cfg.TimeFormat = timeFormatUnix
+	default:
+		return Config{}, fmt.Errorf("invalid TIME_FORMAT %q: must be rfc3339 or unix", v)
+	}
+
+	switch v := os.Getenv("ID_FORMAT"); v {
+	case "", "sequential":
+		cfg.IDFormat = "sequential"
+	case "time":
+		cfg.IDFormat = v
+	case "uuid", "ulid":
+		return Config{}, fmt.Errorf("ID_FORMAT %q is not supported: item IDs are integers in the routes, gRPC and GraphQL; use time for IDs that sort by creation", v)
+	default:
+		return Config{}, fmt.Errorf("invalid ID_FORMAT %q: must be sequential or time", v)
+	}
+
+	if cfg.MaxItems, err = positiveIntEnv("MAX_ITEMS", 0); err != nil {
+		return Config{}, err
+	}
+
+	// UNIQUE_NAMES=true predates UNIQUE_SCOPE and means the global scope.
+	cfg.UniqueScope = UniqueNone
+	if v := os.Getenv("UNIQUE_NAMES"); v != "" {
+		unique, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid UNIQUE_NAMES %q: must be true or false", v)
+		}
+		if unique {
+			cfg.UniqueScope = UniqueGlobal
+		}
+	}
+	switch v := os.Getenv("UNIQUE_SCOPE"); v {
+	case "":
+	case string(UniqueNone), string(UniqueGlobal):
+		cfg.UniqueScope = UniqueScope(v)
+	case "owner", "category":
+		return Config{}, fmt.Errorf("invalid UNIQUE_SCOPE %q: items have no %s yet; must be none or global", v, v)
+	default:
+		return Config{}, fmt.Errorf("invalid UNIQUE_SCOPE %q: must be none or global", v)
+	}
+
+	if cfg.MaxTags, err = positiveIntEnv("MAX_TAGS", defaultMaxTags); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxTagLength, err = positiveIntEnv("MAX_TAG_LENGTH", defaultMaxTagLength); err != nil {
+		return Config{}, err
+	}
+	if cfg.SlugLength, err = positiveIntEnv("SLUG_LENGTH", defaultSlugLength); err != nil {
+		return Config{}, err
+	}
+
+	cfg.WALPath = os.Getenv("WAL_PATH")
+	if cfg.WALCompactAfter, err = positiveIntEnv("WAL_COMPACT_AFTER", defaultWALCompactAfter); err != nil {
+		return Config{}, err
+	}
+	if v := os.Getenv("WAL_SYNC"); v != "" {
+		if cfg.WALSync, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid WAL_SYNC %q: must be true or false", v)
+		}
+	}
+
+	if cfg.MaxBodyBytes, err = positiveIntEnv("MAX_BODY_BYTES", defaultMaxBodyBytes); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxJSONDepth, err = positiveIntEnv("MAX_JSON_DEPTH", defaultMaxJSONDepth); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxJSONElements, err = positiveIntEnv("MAX_JSON_ELEMENTS", defaultMaxJSONElements); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.PageSize, err = positiveIntEnv("DEFAULT_PAGE_SIZE", defaultPageSize); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxPageSize, err = positiveIntEnv("MAX_PAGE_SIZE", defaultMaxPage); err != nil {
+		return Config{}, err
+	}
+	if cfg.PageSize > cfg.MaxPageSize {
+		return Config{}, fmt.Errorf("DEFAULT_PAGE_SIZE (%d) must not exceed MAX_PAGE_SIZE (%d)", cfg.PageSize, cfg.MaxPageSize)
+	}
+
+	if cfg.RateLimit, err = positiveIntEnv("RATE_LIMIT", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.RateLimitBurst, err = positiveIntEnv("RATE_LIMIT_BURST", cfg.RateLimit); err != nil {
+		return Config{}, err
+	}
+	switch v := os.Getenv("RATE_LIMIT_MODE"); v {
+	case "":
+	case "enforce", "report":
+		cfg.RateLimitMode = v
+	default:
+		return Config{}, fmt.Errorf("invalid RATE_LIMIT_MODE %q: must be enforce or report", v)
+	}
+
+	if v := os.Getenv("DEBUG"); v != "" {
+		if cfg.Debug, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid DEBUG %q: must be true or false", v)
+		}
+	}
+	if v := os.Getenv("SELFTEST"); v != "" {
+		if cfg.SelfTest, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid SELFTEST %q: must be true or false", v)
+		}
+	}
+	if v := os.Getenv("RESPONSE_TIME_HEADER"); v != "" {
+		if cfg.ResponseTimeHeader, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid RESPONSE_TIME_HEADER %q: must be true or false", v)
+		}
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := cfg.LogLevel.UnmarshalText([]byte(v)); err != nil {
+			return Config{}, fmt.Errorf("invalid LOG_LEVEL %q: must be debug, info, warn or error", v)
+		}
+	}
+	if v := os.Getenv("CORS_CREDENTIALS"); v != "" {
+		if cfg.CORSCredentials, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid CORS_CREDENTIALS %q: must be true or false", v)
+		}
+	}
+
+	if cfg.ReadyMaxItems, err = positiveIntEnv("READY_MAX_ITEMS", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.ReadyMaxHeapMB, err = positiveIntEnv("READY_MAX_HEAP_MB", 0); err != nil {
+		return Config{}, err
+	}
+	healthCacheMS, err := positiveIntEnv("HEALTH_CACHE_MS", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.HealthCacheTTL = time.Duration(healthCacheMS) * time.Millisecond
+
+	slowMS, err := positiveIntEnv("SLOW_THRESHOLD_MS", int(defaultSlowThreshold/time.Millisecond))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.SlowThreshold = time.Duration(slowMS) * time.Millisecond
+
+	if cfg.CachePolicies, err = parseCachePolicies(os.Getenv("CACHE_POLICIES")); err != nil {
+		return Config{}, err
+	}
+	cfg.DisabledEndpoints = listEnv("DISABLED_ENDPOINTS", nil)
+	cfg.DefaultSort = os.Getenv("DEFAULT_SORT")
+	if _, err := parseSortSpec(cfg.DefaultSort); err != nil {
+		return Config{}, fmt.Errorf("invalid DEFAULT_SORT %q: %w", cfg.DefaultSort, err)
+	}
+	if cfg.ImportTimeout, err = positiveDurationEnv("IMPORT_TIMEOUT", defaultImportTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.ImportMaxBytes, err = positiveIntEnv("IMPORT_MAX_BYTES", defaultImportMaxBytes); err != nil {
+		return Config{}, err
+	}
+	if v := os.Getenv("IMPORT_ALLOW_PRIVATE"); v != "" {
+		if cfg.ImportAllowPrivate, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid IMPORT_ALLOW_PRIVATE %q: must be true or false", v)
+		}
+	}
+
+	cfg.WebhooksPath = os.Getenv("WEBHOOKS_PATH")
+	if cfg.WebhookTimeout, err = positiveDurationEnv("WEBHOOK_TIMEOUT", defaultWebhookTimeout); err != nil {
+		return Config{}, err
+	}
+	if v := os.Getenv("WEBHOOK_ALLOW_PRIVATE"); v != "" {
+		if cfg.WebhookAllowPrivate, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid WEBHOOK_ALLOW_PRIVATE %q: must be true or false", v)
+		}
+	}
+	if cfg.BreakerFailures, err = positiveIntEnv("BREAKER_FAILURES", defaultBreakerFailures); err != nil {
+		return Config{}, err
+	}
+	if cfg.BreakerCooldown, err = positiveDurationEnv("BREAKER_COOLDOWN", defaultBreakerCooldown); err != nil {
+		return Config{}, err
+	}
+	if v := os.Getenv("STRICT_QUERY"); v != "" {
+		if cfg.StrictQuery, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid STRICT_QUERY %q: must be true or false", v)
+		}
+	}
+	if cfg.RequestTimeout, err = positiveDurationEnv("REQUEST_TIMEOUT", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.RouteTimeouts, err = parseRouteTimeouts(os.Getenv("ROUTE_TIMEOUTS")); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.MaxConcurrent, err = positiveIntEnv("MAX_CONCURRENT", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxConcurrentWait, err = positiveDurationEnv("MAX_CONCURRENT_WAIT", 0); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.TrustedProxies, err = parseTrustedProxies(listEnv("TRUSTED_PROXIES", nil)); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.ListCacheTTL, err = positiveDurationEnv("LIST_CACHE_TTL", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.SnapshotInterval, err = positiveDurationEnv("SNAPSHOT_INTERVAL", 0); err != nil {
+		return Config{}, err
+	}
+	// Periodic snapshots are labeled by the second they were taken.
+	if cfg.SnapshotInterval > 0 && cfg.SnapshotInterval < time.Second {
+		return Config{}, fmt.Errorf("invalid SNAPSHOT_INTERVAL %q: must be at least 1s", os.Getenv("SNAPSHOT_INTERVAL"))
+	}
+	if cfg.SnapshotRetain, err = positiveIntEnv("SNAPSHOT_RETAIN", defaultSnapshotRetain); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.ExpirySweepInterval, err = positiveDurationEnv("EXPIRY_SWEEP_INTERVAL", defaultExpirySweepInterval); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.DueSoonLead, err = positiveDurationEnv("DUE_SOON_LEAD", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.DueSoonInterval, err = positiveDurationEnv("DUE_SOON_INTERVAL", defaultDueSoonInterval); err != nil {
+		return Config{}, err
+	}
+	if v := os.Getenv("DUE_SOON_WEBHOOK_URL"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Config{}, fmt.Errorf("invalid DUE_SOON_WEBHOOK_URL %q: must be an absolute http or https URL", v)
+		}
+		cfg.DueSoonWebhook = v
+	}
+
+	delayMS, err := positiveIntEnv("CHAOS_DELAY_MS", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.ChaosDelay = time.Duration(delayMS) * time.Millisecond
+	if v := os.Getenv("CHAOS_ERROR_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return Config{}, fmt.Errorf("invalid CHAOS_ERROR_RATE %q: must be between 0 and 1", v)
+		}
+		cfg.ChaosErrorRate = rate
+	}
+	if v := os.Getenv("CHAOS_SEED"); v != "" {
+		if cfg.ChaosSeed, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return Config{}, fmt.Errorf("invalid CHAOS_SEED %q: must be an integer", v)
+		}
+	}
+
+	return cfg, nil
+}
+
+// checkPort fails unless v is a bare port number, catching values such as
+// tcp://0.0.0.0:8080 that would otherwise surface as a cryptic bind error.
+func checkPort(name, v string) error {
+	if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 65535 || strconv.Itoa(n) != v {
+		return fmt.Errorf("invalid %s %q: must be a port number from 1 to 65535", name, v)
+	}
+	return nil
+}
+
+// positiveIntEnv reads a positive integer from the environment, returning def
+// when the variable is unset.
+func positiveIntEnv(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive integer", name, v)
+	}
+	return n, nil
+}
+
+// positiveDurationEnv reads a positive duration such as 15s from the
+// environment, returning def when the variable is unset.
+func positiveDurationEnv(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive duration such as 15s", name, v)
+	}
+	return d, nil
+}
+
+// listEnv reads a comma-separated list from the environment, returning def
+// when the variable is unset or empty.
+func listEnv(name string, def []string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	if len(list) == 0 {
+		return def
+	}
+	return list
+}
+
+// secretFieldPattern matches Config fields whose values must never be shown.
+var secretFieldPattern = regexp.MustCompile(`(?i)key|secret|password|token|connection`)
+
+// redacted renders the config for display with secrets replaced by "***".
+// URLs, whatever their field is called, lose the credentials and query
+// values they may carry. Durations are shown in their readable form.
+func (c Config) redacted() map[string]any {
+	v := reflect.ValueOf(c)
+	t := v.Type()
+
+	fields := make(map[string]any, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		value := v.Field(i).Interface()
+		switch {
+		case secretFieldPattern.MatchString(name):
+			if !v.Field(i).IsZero() {
+				value = "***"
+			}
+		case t.Field(i).Type == reflect.TypeOf(time.Duration(0)):
+			value = value.(time.Duration).String()
+		case t.Field(i).Type == reflect.TypeOf(""):
+			value = redactURL(value.(string))
+		}
+		fields[name] = value
+	}
+	return fields
+}
+
+// redactURL replaces the userinfo and query values of an absolute URL with
+// "***", leaving anything else as it is.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" || (u.User == nil && u.RawQuery == "") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteString(u.Scheme + "://")
+	if u.User != nil {
+		b.WriteString("***@")
+	}
+	b.WriteString(u.Host + u.EscapedPath())
+	sep := "?"
+	for _, k := range slices.Sorted(maps.Keys(u.Query())) {
+		b.WriteString(sep + url.QueryEscape(k) + "=***")
+		sep = "&"
+	}
+	if u.Fragment != "" {
+		b.WriteString("#" + u.EscapedFragment())
+	}
+	return b.String()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// findRoute returns the chi pattern of the route mux would serve r with, or
+// "" when none matches. Middleware runs before routing, so it has to ask.
+func findRoute(mux *chi.Mux, r *http.Request) string {
+	path := r.URL.Path
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePath != "" {
+		path = rctx.RoutePath
+	}
+	return mux.Find(chi.NewRouteContext(), r.Method, path)
+}
+
+// checkEndpoints fails unless every DISABLED_ENDPOINTS entry names a route of
+// mux, either as a pattern such as /items/stats or, to disable one method
+// only, as METHOD pattern such as "DELETE /items/{id}".
+func checkEndpoints(mux *chi.Mux, disabled []string) error {
+	known := map[string]bool{}
+	err := chi.Walk(mux, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		known[route] = true
+		known[method+" "+route] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range disabled {
+		if !known[entry] {
+			return fmt.Errorf("invalid DISABLED_ENDPOINTS entry %q: must be a route pattern, optionally after a method, that the server serves", entry)
+		}
+	}
+	return nil
+}
+
+// disableEndpoints answers 404 for the routes disabled lists, as if they had
+// never been registered.
+func disableEndpoints(mux *chi.Mux, disabled []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := findRoute(mux, r)
+			if route != "" && (slices.Contains(disabled, route) || slices.Contains(disabled, r.Method+" "+route)) {
+				http.NotFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envFile overlays KEY=VALUE lines from a file onto the process environment.
+// A process can't have its environment changed from outside, so this is what
+// gives a SIGHUP reload something new to read. Blank lines and lines starting
+// with # are skipped.
+type envFile struct {
+	path string
+	// original is the environment the file's keys replaced, nil where a key
+	// was unset, so keys dropped from the file revert on the next apply.
+	original map[string]*string
+}
+
+func (f *envFile) apply() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return fmt.Errorf("%s:%d: want KEY=VALUE", f.path, n)
+		}
+		values[key] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for key, value := range f.original {
+		if value == nil {
+			os.Unsetenv(key)
+		} else {
+			os.Setenv(key, *value)
+		}
+	}
+	f.original = make(map[string]*string, len(values))
+	for key, value := range values {
+		if prev, ok := os.LookupEnv(key); ok {
+			f.original[key] = &prev
+		} else {
+			f.original[key] = nil
+		}
+		os.Setenv(key, value)
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// subscriberBuffer is how many events a subscriber can fall behind before
+// publishing to it starts to block.
+const subscriberBuffer = 16
+
+// rememberDeleted is how many of the latest deletions the broadcaster checks
+// updates against.
+const rememberDeleted = 1024
+
+type itemEvent struct {
+	kind string
+	data []byte
+	// item is the ID of the item the event is about, when it is about one.
+	item    int
+	hasItem bool
+}
+
+// endsItem reports whether the event means the item a stream watches is gone.
+func (e itemEvent) endsItem() bool {
+	return e.kind == "deleted" || e.kind == "reset"
+}
+
+type subscriber struct {
+	events chan itemEvent
+	// item, when watching is set, is the only item whose events this
+	// subscriber gets; resets, which replace every item, reach it too.
+	item     int
+	watching bool
+	// done is closed once the subscriber is removed, whether it left or was
+	// dropped.
+	done chan struct{}
+	// lastActive is when a write to the client last succeeded, in Unix
+	// nanoseconds.
+	lastActive atomic.Int64
+}
+
+// wants reports whether the event should be delivered to the subscriber.
+func (s *subscriber) wants(e itemEvent) bool {
+	if !s.watching || e.kind == "reset" {
+		return true
+	}
+	return e.hasItem && e.item == s.item
+}
+
+// active records a successful write to the subscriber's connection.
+func (s *subscriber) active(t time.Time) {
+	s.lastActive.Store(t.UnixNano())
+}
+
+// broadcaster fans item events out to server-sent event subscribers. A
+// subscriber that can't accept an event within sendTimeout is dropped so
+// stalled clients can't hold up the writes that publish, and one with no
+// successful write for idleTimeout is reaped.
+type broadcaster struct {
+	sendTimeout time.Duration
+	idleTimeout time.Duration
+	logger      *log.Logger
+
+	mu      sync.Mutex
+	subs    map[*subscriber]struct{}
+	hooks   []func(itemEvent)
+	deleted deletedIDs
+	dropped atomic.Int64
+	reaped  atomic.Int64
+}
+
+func newBroadcaster(sendTimeout, idleTimeout time.Duration, logger *log.Logger) *broadcaster {
+	return &broadcaster{
+		sendTimeout: sendTimeout,
+		idleTimeout: idleTimeout,
+		logger:      logger,
+		subs:        make(map[*subscriber]struct{}),
+		deleted:     deletedIDs{ids: make(map[int]bool)},
+	}
+}
+
+// deletedIDs remembers the latest deleted items, so an update that the store
+// applied before a delete but that is published after it can be left out.
+// Subscribers would otherwise see the item come back.
+type deletedIDs struct {
+	ids   map[int]bool
+	order []int // oldest first, at most rememberDeleted long
+}
+
+func (d *deletedIDs) add(id int) {
+	if d.ids[id] {
+		return
+	}
+	if len(d.order) == rememberDeleted {
+		delete(d.ids, d.order[0])
+		d.order = d.order[1:]
+	}
+	d.ids[id] = true
+	d.order = append(d.order, id)
+}
+
+// forget drops id once it names an item again, as after a create with that
+// ID.
+func (d *deletedIDs) forget(id int) {
+	if d.ids[id] {
+		delete(d.ids, id)
+		d.order = slices.DeleteFunc(d.order, func(v int) bool { return v == id })
+	}
+}
+
+func (d *deletedIDs) reset() {
+	clear(d.ids)
+	d.order = d.order[:0]
+}
+
+// heartbeatInterval is how often a subscriber's handler writes a heartbeat.
+// Three chances per idle timeout keep a live client clear of the reaper.
+func (b *broadcaster) heartbeatInterval() time.Duration {
+	return b.idleTimeout / 3
+}
+
+func (b *broadcaster) subscribe() *subscriber {
+	return b.add(&subscriber{})
+}
+
+// watch subscribes to the events of the item with the given id only.
+func (b *broadcaster) watch(id int) *subscriber {
+	return b.add(&subscriber{item: id, watching: true})
+}
+
+func (b *broadcaster) add(s *subscriber) *subscriber {
+	s.events = make(chan itemEvent, subscriberBuffer)
+	s.done = make(chan struct{})
+	s.active(time.Now())
+
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// onPublish calls fn with every event published from then on, before the
+// subscribers get it. fn must not block.
+func (b *broadcaster) onPublish(fn func(itemEvent)) {
+	b.mu.Lock()
+	b.hooks = append(b.hooks, fn)
+	b.mu.Unlock()
+}
+
+func (b *broadcaster) unsubscribe(s *subscriber) {
+	b.remove(s)
+}
+
+// drop removes a stalled subscriber, which makes its handler close the
+// connection.
+func (b *broadcaster) drop(s *subscriber) {
+	if b.remove(s) {
+		b.logger.Printf("Dropped SSE subscriber blocked for over %s (%d dropped so far)", b.sendTimeout, b.dropped.Add(1))
+	}
+}
+
+// reapIdle removes subscribers idle for over idleTimeout until ctx is done.
+// Their connections most likely died without the server noticing, as happens
+// behind proxies, and the handler goroutines would otherwise linger.
+func (b *broadcaster) reapIdle(ctx context.Context) {
+	ticker := time.NewTicker(b.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.reap(now)
+		}
+	}
+}
+
+func (b *broadcaster) reap(now time.Time) {
+	b.mu.Lock()
+	var idle []*subscriber
+	for s := range b.subs {
+		if now.Sub(time.Unix(0, s.lastActive.Load())) > b.idleTimeout {
+			idle = append(idle, s)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, s := range idle {
+		if b.remove(s) {
+			b.logger.Printf("Reaped SSE subscriber idle for over %s (%d reaped so far)", b.idleTimeout, b.reaped.Add(1))
+		}
+	}
+}
+
+// count is how many subscribers are connected.
+func (b *broadcaster) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+func (b *broadcaster) remove(s *subscriber) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[s]; !ok {
+		return false
+	}
+	delete(b.subs, s)
+	close(s.done)
+	return true
+}
+
+// publish encodes v once and delivers it to every subscriber that wants it.
+// Events whose data has an id are about that item; those for an item that was
+// just deleted are dropped, since the delete won.
+func (b *broadcaster) publish(kind string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		b.logger.Printf("Encoding %s event: %v", kind, err)
+		return
+	}
+	event := itemEvent{kind: kind, data: data}
+	var about struct {
+		ID *int `json:"id"`
+	}
+	if json.Unmarshal(data, &about) == nil && about.ID != nil {
+		event.item, event.hasItem = *about.ID, true
+	}
+
+	b.mu.Lock()
+	switch {
+	case kind == "reset":
+		b.deleted.reset()
+	case !event.hasItem:
+	case kind == "deleted":
+		b.deleted.add(event.item)
+	case kind == "created":
+		b.deleted.forget(event.item)
+	case b.deleted.ids[event.item]:
+		b.mu.Unlock()
+		return
+	}
+	subs := make([]*subscriber, 0, len(b.subs))
+	for s := range b.subs {
+		if s.wants(event) {
+			subs = append(subs, s)
+		}
+	}
+	hooks := b.hooks
+	b.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn(event)
+	}
+
+	var stalled []*subscriber
+	for _, s := range subs {
+		select {
+		case s.events <- event:
+		default:
+			stalled = append(stalled, s)
+		}
+	}
+	if len(stalled) == 0 {
+		return
+	}
+
+	// The stalled subscribers share one deadline, so however many there are
+	// the write that published waits sendTimeout at most.
+	timer := time.NewTimer(b.sendTimeout)
+	defer timer.Stop()
+	expired := false
+	for _, s := range stalled {
+		if !expired {
+			select {
+			case s.events <- event:
+				continue
+			case <-s.done:
+				continue
+			case <-timer.C:
+				expired = true
+			}
+		}
+		select {
+		case s.events <- event:
+		default:
+			b.drop(s)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestPublishDropsStalledSubscribersTogether(t *testing.T) {
+	const sendTimeout = 50 * time.Millisecond
+	b := newBroadcaster(sendTimeout, time.Hour, log.New(io.Discard, "", 0))
+
+	// None of these read, so once their buffers fill every one stalls.
+	stalled := make([]*subscriber, 5)
+	for i := range stalled {
+		stalled[i] = b.subscribe()
+	}
+	live := b.subscribe()
+	for range subscriberBuffer {
+		b.publish("created", map[string]int{"id": 1})
+		<-live.events
+	}
+
+	got := make(chan itemEvent, 1)
+	go func() { got <- <-live.events }()
+	start := time.Now()
+	b.publish("updated", map[string]int{"id": 1})
+	if took := time.Since(start); took >= 2*sendTimeout {
+		t.Errorf("publish with %d stalled subscribers took %s, want under %s", len(stalled), took, 2*sendTimeout)
+	}
+
+	for i, s := range stalled {
+		select {
+		case <-s.done:
+		default:
+			t.Errorf("stalled subscriber %d was not dropped", i)
+		}
+	}
+	if n := b.count(); n != 1 {
+		t.Errorf("%d subscribers left, want only the one reading", n)
+	}
+	select {
+	case e := <-got:
+		if e.kind != "updated" {
+			t.Errorf("reading subscriber got %q, want updated", e.kind)
+		}
+	case <-time.After(time.Second):
+		t.Error("reading subscriber never got the event")
+	}
+}
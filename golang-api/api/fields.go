@@ -0,0 +1,116 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// itemField describes an item field, by its JSON name, to the code that
+// handles fields by name: list sorting, range filters and search, and the
+// checks on PATCH. Every JSON field of an item is registered here, so a new
+// field is added in one place.
+type itemField struct {
+	name string
+
+	// readOnly fields are set by the store, never by writes. computed ones
+	// aren't stored at all; responses work them out.
+	readOnly bool
+	computed bool
+
+	// compare orders items by the field, ascending. A collated field is
+	// compared by its collate text instead, for the request's locale.
+	compare func(a, b *Item) int
+	collate func(*Item) string
+
+	// rangeParam is the prefix of the After and Before parameters that bound
+	// the time at returns, inclusively; items where it is nil never match.
+	rangeParam string
+	at         func(*Item) *time.Time
+
+	// text is what q matches in the field, lowercased.
+	text func(*Item) []string
+}
+
+func (f itemField) sortable() bool {
+	return f.compare != nil || f.collate != nil
+}
+
+var itemFields = []itemField{
+	{name: "id", readOnly: true, compare: func(a, b *Item) int { return cmp.Compare(a.ID, b.ID) }},
+	{
+		name:    "name",
+		collate: func(item *Item) string { return item.Name },
+		text:    func(item *Item) []string { return []string{strings.ToLower(item.Name)} },
+	},
+	{name: "slug", readOnly: true, compare: func(a, b *Item) int { return cmp.Compare(a.Slug, b.Slug) }},
+	{name: "completed", compare: func(a, b *Item) int { return compareBool(a.Completed, b.Completed) }},
+	{name: "estimateMinutes", compare: func(a, b *Item) int { return cmp.Compare(a.EstimateMinutes, b.EstimateMinutes) }},
+	// Tags are stored lowercased.
+	{name: "tags", text: func(item *Item) []string { return item.Tags }},
+	{
+		name:       "createdAt",
+		readOnly:   true,
+		compare:    func(a, b *Item) int { return a.CreatedAt.Compare(b.CreatedAt) },
+		rangeParam: "created",
+		at:         func(item *Item) *time.Time { return &item.CreatedAt },
+	},
+	{name: "updatedAt", readOnly: true, compare: func(a, b *Item) int { return a.UpdatedAt.Compare(b.UpdatedAt) }},
+	{
+		name:       "completedAt",
+		readOnly:   true,
+		compare:    func(a, b *Item) int { return compareOptionalTime(a.CompletedAt, b.CompletedAt) },
+		rangeParam: "completed",
+		at:         func(item *Item) *time.Time { return item.CompletedAt },
+	},
+	{name: "dueDate", compare: func(a, b *Item) int { return compareOptionalTime(a.DueDate, b.DueDate) }},
+	{name: "expiresAt"},
+	{name: "blockedBy"},
+	{name: "blocked", readOnly: true, compare: func(a, b *Item) int { return compareBool(a.Blocked, b.Blocked) }},
+	{name: "progress", readOnly: true, computed: true},
+}
+
+// fieldsByName indexes itemFields, after checking they match the JSON form of
+// an item exactly.
+var fieldsByName = func() map[string]itemField {
+	byName := make(map[string]itemField, len(itemFields))
+	for _, f := range itemFields {
+		byName[f.name] = f
+	}
+
+	now := time.Now()
+	b, err := json.Marshal(newItemResponse(&Item{Tags: []string{"t"}, BlockedBy: []int{1}, CompletedAt: &now, DueDate: &now, ExpiresAt: &now}))
+	if err != nil {
+		panic(err)
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(b, &members); err != nil {
+		panic(err)
+	}
+	for name := range members {
+		if _, ok := byName[name]; !ok {
+			panic(fmt.Sprintf("item field %q is missing from itemFields", name))
+		}
+	}
+	for name := range byName {
+		if _, ok := members[name]; !ok {
+			panic(fmt.Sprintf("itemFields has %q, which items don't", name))
+		}
+	}
+	return byName
+}()
+
+// fieldNames lists, sorted, the fields for which keep is true.
+func fieldNames(keep func(itemField) bool) []string {
+	var names []string
+	for _, f := range itemFields {
+		if keep(f) {
+			names = append(names, f.name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
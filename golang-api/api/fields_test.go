@@ -0,0 +1,38 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+// BenchmarkSortByField sorts a page's worth of items by each sortable field,
+// each one looked up in the registry for every comparison.
+func BenchmarkSortByField(b *testing.B) {
+	items := itemsOf(b, 1000)
+	for _, name := range fieldNames(itemField.sortable) {
+		b.Run(name, func(b *testing.B) {
+			order := itemSort{keys: []sortKey{{field: name}}, locale: language.English}
+			sorted := make([]*Item, len(items))
+			b.ReportAllocs()
+			for range b.N {
+				copy(sorted, items)
+				order.apply(sorted)
+			}
+		})
+	}
+}
+
+// BenchmarkSearchFields matches q against every searchable field through the
+// registry's text accessors.
+func BenchmarkSearchFields(b *testing.B) {
+	items := itemsOf(b, 1000)
+	filter := Filter{Query: "item 99", QueryIn: slices.Clone(searchFields)}
+	b.ReportAllocs()
+	for range b.N {
+		for _, item := range items {
+			filter.Matches(item)
+		}
+	}
+}
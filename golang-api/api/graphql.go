@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+)
+
+var graphqlItemType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Item",
+	Fields: graphql.Fields{
+		"id":              &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"name":            &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"slug":            &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"completed":       &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"estimateMinutes": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"tags":            &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))},
+		"createdAt":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"updatedAt":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"completedAt":     &graphql.Field{Type: graphql.String},
+		"dueDate":         &graphql.Field{Type: graphql.String},
+		"expiresAt":       &graphql.Field{Type: graphql.String},
+		"blockedBy":       &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int)))},
+		"blocked":         &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"progress":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+// graphqlItem flattens an item for the default resolvers, formatting its
+// timestamps like the REST responses do.
+func graphqlItem(item *Item) map[string]any {
+	createdAt, _ := Timestamp(item.CreatedAt).MarshalText()
+	updatedAt, _ := Timestamp(item.UpdatedAt).MarshalText()
+	tags := item.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	blockedBy := item.BlockedBy
+	if blockedBy == nil {
+		blockedBy = []int{}
+	}
+	fields := map[string]any{
+		"id":              item.ID,
+		"name":            item.Name,
+		"slug":            item.Slug,
+		"completed":       item.Completed,
+		"estimateMinutes": item.EstimateMinutes,
+		"tags":            tags,
+		"blockedBy":       blockedBy,
+		"blocked":         item.Blocked,
+		"createdAt":       string(createdAt),
+		"updatedAt":       string(updatedAt),
+		"progress":        item.Progress(),
+	}
+	if item.CompletedAt != nil {
+		completedAt, _ := Timestamp(*item.CompletedAt).MarshalText()
+		fields["completedAt"] = string(completedAt)
+	}
+	if item.DueDate != nil {
+		dueDate, _ := Timestamp(*item.DueDate).MarshalText()
+		fields["dueDate"] = string(dueDate)
+	}
+	if item.ExpiresAt != nil {
+		expiresAt, _ := Timestamp(*item.ExpiresAt).MarshalText()
+		fields["expiresAt"] = string(expiresAt)
+	}
+	return fields
+}
+
+// stringList converts a GraphQL list argument, reporting false when absent.
+func stringList(args map[string]any, name string) ([]string, bool) {
+	raw, ok := args[name].([]any)
+	if !ok {
+		return nil, false
+	}
+	list := make([]string, 0, len(raw))
+	for _, v := range raw {
+		list = append(list, v.(string))
+	}
+	return list, true
+}
+
+var errMaintenance = errors.New("service is in maintenance mode; writes are disabled")
+
+func (a *App) graphqlSchema() (graphql.Schema, error) {
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"items": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphqlItemType))),
+				Args: graphql.FieldConfigArgument{
+					"completed": &graphql.ArgumentConfig{Type: graphql.Boolean},
+					"tag":       &graphql.ArgumentConfig{Type: graphql.String},
+					"q":         &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					var filter Filter
+					if completed, ok := p.Args["completed"].(bool); ok {
+						filter.Completed = &completed
+					}
+					filter.Tag, _ = p.Args["tag"].(string)
+					filter.Query, _ = p.Args["q"].(string)
+
+					items := a.storeFor(p.Context).Query(filter)
+					result := make([]map[string]any, len(items))
+					for i, item := range items {
+						result[i] = graphqlItem(item)
+					}
+					return result, nil
+				},
+			},
+			"item": &graphql.Field{
+				Type: graphqlItemType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					item, ok := a.storeFor(p.Context).Get(p.Args["id"].(int))
+					if !ok {
+						return nil, nil
+					}
+					return graphqlItem(item), nil
+				},
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createItem": &graphql.Field{
+				Type: graphqlItemType,
+				Args: graphql.FieldConfigArgument{
+					"name":            &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"completed":       &graphql.ArgumentConfig{Type: graphql.Boolean},
+					"estimateMinutes": &graphql.ArgumentConfig{Type: graphql.Int},
+					"tags":            &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					if a.maintenance.Load() {
+						return nil, errMaintenance
+					}
+					in := ItemInput{Name: p.Args["name"].(string)}
+					in.Completed, _ = p.Args["completed"].(bool)
+					in.EstimateMinutes, _ = p.Args["estimateMinutes"].(int)
+					in.Tags, _ = stringList(p.Args, "tags")
+					if in.Name == "" {
+						return nil, errors.New("name must not be empty")
+					}
+					if in.EstimateMinutes < 0 {
+						return nil, errors.New("estimateMinutes must not be negative")
+					}
+
+					item, err := a.storeFor(p.Context).Create(in)
+					if err != nil {
+						return nil, err
+					}
+					a.events.publish("created", newItemResponse(item))
+					return graphqlItem(item), nil
+				},
+			},
+			"updateItem": &graphql.Field{
+				Type: graphqlItemType,
+				Args: graphql.FieldConfigArgument{
+					"id":              &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"name":            &graphql.ArgumentConfig{Type: graphql.String},
+					"completed":       &graphql.ArgumentConfig{Type: graphql.Boolean},
+					"estimateMinutes": &graphql.ArgumentConfig{Type: graphql.Int},
+					"tags":            &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					if a.maintenance.Load() {
+						return nil, errMaintenance
+					}
+					var update ItemUpdate
+					if name, ok := p.Args["name"].(string); ok {
+						if name == "" {
+							return nil, errors.New("name must not be empty")
+						}
+						update.Name = &name
+					}
+					if completed, ok := p.Args["completed"].(bool); ok {
+						update.Completed = &completed
+					}
+					if estimate, ok := p.Args["estimateMinutes"].(int); ok {
+						if estimate < 0 {
+							return nil, errors.New("estimateMinutes must not be negative")
+						}
+						update.EstimateMinutes = &estimate
+					}
+					if tags, ok := stringList(p.Args, "tags"); ok {
+						update.Tags = &tags
+					}
+
+					item, changed, err := a.storeFor(p.Context).Update(p.Args["id"].(int), update)
+					if err != nil {
+						return nil, err
+					}
+					if changed {
+						a.events.publish("updated", newItemResponse(item))
+					}
+					return graphqlItem(item), nil
+				},
+			},
+			"deleteItem": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					if a.maintenance.Load() {
+						return nil, errMaintenance
+					}
+					id := p.Args["id"].(int)
+					if _, ok := a.storeFor(p.Context).Delete(id); !ok {
+						return false, nil
+					}
+					a.events.publish("deleted", map[string]int{"id": id})
+					return true, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+// serveGraphQL executes a query posted as {"query", "variables",
+// "operationName"}. GraphQL reports errors in the body, so the status is 200
+// whenever the request itself could be read.
+func (a *App) serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query         string         `json:"query"`
+		Variables     map[string]any `json:"variables"`
+		OperationName string         `json:"operationName"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&req); err != nil || req.Query == "" {
+		http.Error(w, "Request body must be {\"query\": \"...\"}", http.StatusBadRequest)
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         a.graphql,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        r.Context(),
+	})
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (a *App) graphiql(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(graphiqlPage))
+}
+
+const graphiqlPage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>GraphiQL</title>
+  <link rel="stylesheet" href="https://unpkg.com/graphiql@3/graphiql.min.css" />
+</head>
+<body style="margin: 0">
+  <div id="graphiql" style="height: 100vh"></div>
+  <script crossorigin src="https://unpkg.com/react@18/umd/react.production.min.js"></script>
+  <script crossorigin src="https://unpkg.com/react-dom@18/umd/react-dom.production.min.js"></script>
+  <script crossorigin src="https://unpkg.com/graphiql@3/graphiql.min.js"></script>
+  <script>
+    const fetcher = GraphiQL.createFetcher({ url: window.location.pathname });
+    ReactDOM.createRoot(document.getElementById("graphiql")).render(React.createElement(GraphiQL, { fetcher }));
+  </script>
+</body>
+</html>
+`
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"api/itemspb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// itemServer implements the gRPC ItemService over the app's store.
+type itemServer struct {
+	itemspb.UnimplementedItemServiceServer
+	app *App
+}
+
+func toProtoItem(item *Item) *itemspb.Item {
+	pb := &itemspb.Item{
+		Id:              int64(item.ID),
+		Name:            item.Name,
+		Slug:            item.Slug,
+		Completed:       item.Completed,
+		EstimateMinutes: int32(item.EstimateMinutes),
+		Tags:            item.Tags,
+		CreatedAt:       timestamppb.New(item.CreatedAt),
+		UpdatedAt:       timestamppb.New(item.UpdatedAt),
+		Progress:        int32(item.Progress()),
+		Blocked:         item.Blocked,
+	}
+	for _, id := range item.BlockedBy {
+		pb.BlockedBy = append(pb.BlockedBy, int64(id))
+	}
+	if item.CompletedAt != nil {
+		pb.CompletedAt = timestamppb.New(*item.CompletedAt)
+	}
+	if item.DueDate != nil {
+		pb.DueDate = timestamppb.New(*item.DueDate)
+	}
+	if item.ExpiresAt != nil {
+		pb.ExpiresAt = timestamppb.New(*item.ExpiresAt)
+	}
+	return pb
+}
+
+// protoTime converts an optional timestamp field, nil when it is unset.
+func protoTime(ts *timestamppb.Timestamp, field string) (*time.Time, error) {
+	if ts == nil {
+		return nil, nil
+	}
+	if err := ts.CheckValid(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %v", field, err)
+	}
+	t := ts.AsTime()
+	return &t, nil
+}
+
+// protoIDs converts item IDs; the store rejects any that don't exist.
+func protoIDs(ids []int64) []int {
+	converted := make([]int, len(ids))
+	for i, id := range ids {
+		converted[i] = int(id)
+	}
+	return converted
+}
+
+// grpcError maps a store error onto the matching gRPC status.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, "item not found")
+	case errors.Is(err, ErrDuplicateName):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, ErrCapacityReached), errors.Is(err, ErrIDsExhausted):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, ErrInvalidTags), errors.Is(err, ErrInvalidBlockers):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func (s *itemServer) checkWritable() error {
+	if s.app.maintenance.Load() {
+		return status.Error(codes.Unavailable, "service is in maintenance mode; writes are disabled")
+	}
+	return nil
+}
+
+func (s *itemServer) ListItems(ctx context.Context, req *itemspb.ListItemsRequest) (*itemspb.ListItemsResponse, error) {
+	items := s.app.store.Query(Filter{Completed: req.Completed, Tag: req.GetTag(), Query: req.GetQ()})
+	resp := &itemspb.ListItemsResponse{Items: make([]*itemspb.Item, len(items))}
+	for i, item := range items {
+		resp.Items[i] = toProtoItem(item)
+	}
+	return resp, nil
+}
+
+func (s *itemServer) GetItem(ctx context.Context, req *itemspb.GetItemRequest) (*itemspb.Item, error) {
+	item, ok := s.app.store.Get(int(req.GetId()))
+	if !ok {
+		return nil, grpcError(ErrNotFound)
+	}
+	return toProtoItem(item), nil
+}
+
+func (s *itemServer) CreateItem(ctx context.Context, req *itemspb.CreateItemRequest) (*itemspb.Item, error) {
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	if req.GetEstimateMinutes() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "estimate_minutes must not be negative")
+	}
+
+	dueDate, err := protoTime(req.GetDueDate(), "due_date")
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := protoTime(req.GetExpiresAt(), "expires_at")
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := s.app.store.Create(ItemInput{
+		Name:            req.GetName(),
+		Completed:       req.GetCompleted(),
+		EstimateMinutes: int(req.GetEstimateMinutes()),
+		Tags:            req.GetTags(),
+		DueDate:         dueDate,
+		ExpiresAt:       expiresAt,
+		BlockedBy:       protoIDs(req.GetBlockedBy()),
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	s.app.events.publish("created", newItemResponse(item))
+	return toProtoItem(item), nil
+}
+
+func (s *itemServer) UpdateItem(ctx context.Context, req *itemspb.UpdateItemRequest) (*itemspb.Item, error) {
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	var update ItemUpdate
+	if req.Name != nil {
+		if req.GetName() == "" {
+			return nil, status.Error(codes.InvalidArgument, "name must not be empty")
+		}
+		update.Name = req.Name
+	}
+	update.Completed = req.Completed
+	if req.EstimateMinutes != nil {
+		if req.GetEstimateMinutes() < 0 {
+			return nil, status.Error(codes.InvalidArgument, "estimate_minutes must not be negative")
+		}
+		estimate := int(req.GetEstimateMinutes())
+		update.EstimateMinutes = &estimate
+	}
+	if req.Tags != nil {
+		tags := req.GetTags().GetTags()
+		update.Tags = &tags
+	}
+	var err error
+	if update.DueDate, err = protoTime(req.GetDueDate(), "due_date"); err != nil {
+		return nil, err
+	}
+	if update.ExpiresAt, err = protoTime(req.GetExpiresAt(), "expires_at"); err != nil {
+		return nil, err
+	}
+	if req.BlockedBy != nil {
+		blockedBy := protoIDs(req.GetBlockedBy().GetIds())
+		update.BlockedBy = &blockedBy
+	}
+
+	item, changed, err := s.app.store.Update(int(req.GetId()), update)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if changed {
+		s.app.events.publish("updated", newItemResponse(item))
+	}
+	return toProtoItem(item), nil
+}
+
+func (s *itemServer) DeleteItem(ctx context.Context, req *itemspb.DeleteItemRequest) (*itemspb.DeleteItemResponse, error) {
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	id := int(req.GetId())
+	if _, ok := s.app.store.Delete(id); !ok {
+		return nil, grpcError(ErrNotFound)
+	}
+	s.app.events.publish("deleted", map[string]int{"id": id})
+	return &itemspb.DeleteItemResponse{}, nil
+}
+
+// healthWatchInterval is how often a Watch stream re-runs the readiness
+// checks to look for a change.
+const healthWatchInterval = 5 * time.Second
+
+// healthServer implements the standard gRPC health checking protocol from the
+// same checks as /health/ready, so both servers report the same readiness.
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	app *App
+}
+
+// servingStatus maps readiness onto the health protocol. The empty service
+// name means the server as a whole.
+func (s *healthServer) servingStatus(service string) healthpb.HealthCheckResponse_ServingStatus {
+	if service != "" && service != itemspb.ItemService_ServiceDesc.ServiceName {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+	}
+	// Degraded still serves reads, so only unhealthy stops traffic.
+	if status, _ := s.app.ready.run(); status == unhealthy {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+func (s *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st := s.servingStatus(req.GetService())
+	if st == healthpb.HealthCheckResponse_SERVICE_UNKNOWN {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+func (s *healthServer) Watch(req *healthpb.HealthCheckRequest, stream grpc.ServerStreamingServer[healthpb.HealthCheckResponse]) error {
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		if st := s.servingStatus(req.GetService()); st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"api/itemspb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestGRPCWritesDatesAndBlockers(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	srv := &itemServer{app: app}
+	ctx := context.Background()
+	due := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	created, err := srv.CreateItem(ctx, &itemspb.CreateItemRequest{
+		Name:      "Release",
+		DueDate:   timestamppb.New(due),
+		BlockedBy: []int64{1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created.GetDueDate().AsTime().Equal(due) || !slices.Equal(created.GetBlockedBy(), []int64{1}) || !created.GetBlocked() {
+		t.Fatalf("created %v", created)
+	}
+
+	updated, err := srv.UpdateItem(ctx, &itemspb.UpdateItemRequest{
+		Id:        created.GetId(),
+		ExpiresAt: timestamppb.New(due),
+		BlockedBy: &itemspb.IDList{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.GetExpiresAt().AsTime().Equal(due) || len(updated.GetBlockedBy()) != 0 || updated.GetBlocked() {
+		t.Fatalf("updated %v", updated)
+	}
+
+	_, err = srv.UpdateItem(ctx, &itemspb.UpdateItemRequest{
+		Id:        1,
+		BlockedBy: &itemspb.IDList{Ids: []int64{1}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("self-blocking update: %v, want InvalidArgument", err)
+	}
+}
@@ -0,0 +1,1421 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/go-chi/chi/v5"
+)
+
+// streamFlushEvery is how many NDJSON lines are written between flushes.
+const streamFlushEvery = 100
+
+// maxBulkCreate caps the entries of one bulk create, matching the maxItems
+// of the item-bulk-create schema.
+const maxBulkCreate = 100
+
+func (a *App) index(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "Go API with in-memory storage",
+		"version": "1.0.0",
+	})
+}
+
+func (a *App) ping(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"pong":       true,
+		"serverTime": a.now().UTC().Format(time.RFC3339Nano),
+	})
+}
+
+func (a *App) health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":   "healthy",
+		"inFlight": a.inFlight.Load(),
+	})
+}
+
+func (a *App) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	a.metrics.writeOpenMetrics(w, int64(a.storeFor(r.Context()).Stats().Total), a.inFlight.Load(), a.concurrency, a.events)
+}
+
+// readiness reports 503 when any readiness check is unhealthy so load balancers
+// stop sending traffic; degraded checks still answer 200.
+func (a *App) readiness(w http.ResponseWriter, r *http.Request) {
+	status, checks := a.ready.run()
+
+	code := http.StatusOK
+	if status == unhealthy {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]any{
+		"status":      status,
+		"maintenance": a.maintenance.Load(),
+		"checks":      checks,
+	})
+}
+
+func (a *App) setMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&req); err != nil || req.Enabled == nil {
+		http.Error(w, "Request body must be {\"enabled\": true|false}", http.StatusBadRequest)
+		return
+	}
+
+	a.maintenance.Store(*req.Enabled)
+	LoggerFrom(r.Context()).Info("maintenance mode changed", "enabled", *req.Enabled)
+
+	writeJSON(w, http.StatusOK, map[string]bool{"maintenance": *req.Enabled})
+}
+
+// resetItems replaces every item with the seed data, for a clean slate between
+// demos.
+func (a *App) resetItems(w http.ResponseWriter, r *http.Request) {
+	items := newItemResponses(a.storeFor(r.Context()).Reset(seedItems))
+	LoggerFrom(r.Context()).Info("store reset to seed data", "items", len(items))
+
+	writeJSON(w, http.StatusOK, items)
+	a.events.publish("reset", map[string]int{"items": len(items)})
+}
+
+// compactStore compacts the store and reports the heap in use, after a
+// collection, on either side of it.
+func (a *App) compactStore(w http.ResponseWriter, r *http.Request) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	result := a.storeFor(r.Context()).Compact()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	LoggerFrom(r.Context()).Info("store compacted", "items", result.Items, "walEntries", result.WALEntriesAfter)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items":            result.Items,
+		"walEntriesBefore": result.WALEntriesBefore,
+		"walEntriesAfter":  result.WALEntriesAfter,
+		"heapBytesBefore":  before.HeapAlloc,
+		"heapBytesAfter":   after.HeapAlloc,
+	})
+}
+
+func (a *App) debugConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.live.Load().redacted())
+}
+
+// debugInfo reports the Go version and module versions the binary was built
+// with, so deployments can be compared. The items are kept in memory, so there
+// is no database version to report.
+func (a *App) debugInfo(w http.ResponseWriter, r *http.Request) {
+	modules := map[string]string{}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			modules[dep.Path] = dep.Version
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"go":      runtime.Version(),
+		"chi":     modules["github.com/go-chi/chi/v5"],
+		"modules": modules,
+	})
+}
+
+func (a *App) listTags(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, newXMLList("tags", a.storeFor(r.Context()).TagCounts()))
+}
+
+func (a *App) listItems(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+
+	format, ok := negotiate(r)
+	if !ok {
+		writeNotAcceptable(w)
+		return
+	}
+
+	if a.config.StrictQuery {
+		if unknown := unknownParams(r, listParams); len(unknown) > 0 {
+			http.Error(w, "Unknown query parameters: "+strings.Join(unknown, ", "), http.StatusBadRequest)
+			return
+		}
+	}
+	offset, limit, err := parsePage(r, a.config.PageSize, a.config.MaxPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, cursor, err := parseCursor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Cursors page in ID order, so the default sort doesn't apply to them.
+	defaultSort := a.config.DefaultSort
+	if cursor {
+		defaultSort = ""
+	}
+	order, err := parseSort(r, defaultSort)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cursor && !order.byID() {
+		http.Error(w, "Sorted lists page by offset, not after", http.StatusBadRequest)
+		return
+	}
+	if order.collated() && !r.URL.Query().Has("locale") {
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	loc, err := parseTimeZone(r, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// With the cache on, the list is answered entirely from a snapshot.
+	var snap *listSnapshot
+	var version Version
+	if a.listCache != nil {
+		var status string
+		snap, status = a.listCache.get()
+		w.Header().Set("X-Cache", status)
+		version = snap.version
+	} else {
+		version = a.storeFor(r.Context()).Version()
+	}
+
+	// Large JSON pages keep their items instead of a body, to be streamed.
+	type listPage struct {
+		body  []byte
+		items []*Item
+		total int
+		next  int
+	}
+	encode := func(items []*Item) (listPage, error) {
+		if format == formatJSON && len(items) > streamFlushEvery {
+			return listPage{items: items}, nil
+		}
+		responses := newItemResponses(items)
+		for i := range responses {
+			responses[i] = responses[i].in(loc)
+		}
+		body, err := marshalAs(format, newXMLList("items", responses))
+		return listPage{body: body}, err
+	}
+
+	var key string
+	var query func() (any, error)
+	if cursor {
+		key = fmt.Sprintf("%s?after=%d&limit=%d&%s", format, after, limit, filterKey(r))
+		query = func() (any, error) {
+			var items []*Item
+			var next int
+			if snap != nil {
+				items, next = snap.page(filter, after, limit)
+			} else {
+				items, next = a.storeFor(r.Context()).Page(filter, after, limit)
+			}
+			page, err := encode(items)
+			page.next = next
+			return page, err
+		}
+	} else {
+		key = fmt.Sprintf("%s?offset=%d&limit=%d&%s", format, offset, limit, filterKey(r))
+		if !order.byID() {
+			key += "&sort=" + order.String()
+		}
+		query = func() (any, error) {
+			var items []*Item
+			if snap != nil {
+				items = snap.query(filter)
+			} else {
+				items = a.storeFor(r.Context()).Query(filter)
+			}
+			order.apply(items)
+			total := len(items)
+
+			start := min(offset, total)
+			end := min(start+limit, total)
+			page, err := encode(items[start:end])
+			page.total = total
+			return page, err
+		}
+	}
+	if loc != nil {
+		key += "&tz=" + loc.String()
+	}
+
+	// HTTP dates have second resolution, so compare at that precision.
+	lastModified := version.Modified.UTC().Truncate(time.Second)
+	etag := listETag(version, key)
+	w.Header().Set("ETag", etag)
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+
+	if snap != nil {
+		// Only share results computed from the same snapshot.
+		key = fmt.Sprintf("%p/%s", snap, key)
+	} else {
+		// Only join a query that started after this request read version, so
+		// the body is never older than the ETag just set.
+		key = fmt.Sprintf("%d/%s", version.Revision, key)
+	}
+	result, err, _ := a.listQueries.Do(key, query)
+	if err != nil {
+		LoggerFrom(r.Context()).Error("encoding item list", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	page := result.(listPage)
+
+	links := map[string]url.Values{}
+	if cursor {
+		links["first"] = url.Values{"after": {"0"}}
+		if page.next > 0 {
+			w.Header().Set("X-Next-Cursor", strconv.Itoa(page.next))
+			links["next"] = url.Values{"after": {strconv.Itoa(page.next)}}
+		}
+	} else {
+		w.Header().Set("X-Total-Count", strconv.Itoa(page.total))
+		links["first"] = url.Values{"offset": {"0"}}
+		links["last"] = url.Values{"offset": {strconv.Itoa(max(page.total-1, 0) / limit * limit)}}
+		if offset > 0 {
+			links["prev"] = url.Values{"offset": {strconv.Itoa(max(offset-limit, 0))}}
+		}
+		// Compared this way round so a huge offset can't overflow.
+		if offset < page.total-limit {
+			links["next"] = url.Values{"offset": {strconv.Itoa(offset + limit)}}
+		}
+	}
+	setPageLinks(w, r, limit, links)
+	if page.items != nil {
+		writeJSONArray(w, r, page.items, loc)
+		return
+	}
+	writeBody(w, http.StatusOK, contentTypeFor(format), page.body)
+}
+
+// countItems takes the same filters as GET /items.
+func (a *App) countItems(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"count": a.storeFor(r.Context()).Count(filter)})
+}
+
+// itemMetrics reports how many items have been created, completed and deleted
+// since the server started.
+func (a *App) itemMetrics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.storeFor(r.Context()).Totals())
+}
+
+// todayItems lists the items created since midnight in the tz zone, UTC by
+// default, sparing clients the day-boundary math.
+func (a *App) todayItems(w http.ResponseWriter, r *http.Request) {
+	loc, err := parseTimeZone(r, time.UTC)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := a.now().In(loc)
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	items := a.storeFor(r.Context()).Query(Filter{Ranges: map[string]TimeRange{
+		"createdAt": {After: start, Before: start.AddDate(0, 0, 1).Add(-time.Nanosecond)},
+	}})
+	respond(w, r, http.StatusOK, newXMLList("items", newItemResponses(items)))
+}
+
+func (a *App) itemStats(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, a.storeFor(r.Context()).Stats())
+}
+
+func (a *App) itemActivity(w http.ResponseWriter, r *http.Request) {
+	window, interval := time.Hour, 5*time.Minute
+
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxActivityWindow {
+			http.Error(w, fmt.Sprintf("window must be a duration between 0 and %s", maxActivityWindow), http.StatusBadRequest)
+			return
+		}
+		window = d
+		interval = window / 12
+	}
+	if v := r.URL.Query().Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "interval must be a positive duration", http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+	if interval > window {
+		interval = window
+	}
+	if window/interval > maxActivityBuckets {
+		http.Error(w, fmt.Sprintf("window/interval must not exceed %d buckets", maxActivityBuckets), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"window":   window.String(),
+		"interval": interval.String(),
+		"buckets":  a.storeFor(r.Context()).Activity(window, interval),
+	})
+}
+
+func (a *App) randomItem(w http.ResponseWriter, r *http.Request) {
+	includeCompleted := false
+	if v := r.URL.Query().Get("includeCompleted"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "includeCompleted must be true or false", http.StatusBadRequest)
+			return
+		}
+		includeCompleted = b
+	}
+
+	item, ok := a.storeFor(r.Context()).Random(!includeCompleted)
+	if !ok {
+		http.Error(w, "No matching items", http.StatusNotFound)
+		return
+	}
+
+	respond(w, r, http.StatusOK, newItemResponse(item))
+}
+
+func (a *App) oldestPendingItem(w http.ResponseWriter, r *http.Request) {
+	item, ok := a.storeFor(r.Context()).OldestPending()
+	if !ok {
+		http.Error(w, "No pending items", http.StatusNotFound)
+		return
+	}
+
+	respond(w, r, http.StatusOK, newItemResponse(item))
+}
+
+// streamItems writes every item as NDJSON as it goes. Byte ranges would need
+// the whole export buffered to resolve offsets, so Range is ignored and the
+// response says so; an interrupted download starts over. A client that goes
+// away mid-stream stops the export at the next item.
+func (a *App) streamItems(w http.ResponseWriter, r *http.Request) {
+	items := a.storeFor(r.Context()).GetAll()
+	flusher, _ := w.(http.Flusher)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Accept-Ranges", "none")
+	enc := json.NewEncoder(w)
+	for i, item := range items {
+		if exportCancelled(r, i, len(items)) {
+			return
+		}
+		if err := enc.Encode(newItemResponse(item)); err != nil {
+			return
+		}
+		if flusher != nil && (i+1)%streamFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// itemEvents streams item changes as server-sent events until the client
+// goes away or falls too far behind. Heartbeat comments between events keep
+// proxies from timing the stream out and tell the reaper the client is alive.
+func (a *App) itemEvents(w http.ResponseWriter, r *http.Request) {
+	sub := a.events.subscribe()
+	defer a.events.unsubscribe(sub)
+	a.streamEvents(w, r, sub)
+}
+
+// watchItem streams the changes to one item as server-sent events, closing
+// the stream once the item is deleted.
+func (a *App) watchItem(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Subscribe first so no change slips in between the check and the stream.
+	sub := a.events.watch(id)
+	defer a.events.unsubscribe(sub)
+	if _, ok := a.storeFor(r.Context()).Get(id); !ok {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	a.streamEvents(w, r, sub)
+}
+
+// streamEvents writes sub's events to the client until it goes away, falls
+// too far behind or, for a watched item, the item is gone.
+func (a *App) streamEvents(w http.ResponseWriter, r *http.Request, sub *subscriber) {
+	// Checked before anything is written, so the 500 can still be sent.
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	rc := http.NewResponseController(w)
+
+	// send reports whether the handler should keep streaming, which it stops
+	// doing once a write fails. A client that stops reading would otherwise
+	// block the write forever; if the write took the whole deadline, the
+	// client stalled rather than went away.
+	send := func(format string, args ...any) bool {
+		start := time.Now()
+		rc.SetWriteDeadline(start.Add(a.config.SSESendTimeout))
+		_, err := fmt.Fprintf(w, format, args...)
+		if err == nil {
+			flusher.Flush()
+		}
+		if err != nil || r.Context().Err() != nil {
+			if time.Since(start) >= a.config.SSESendTimeout {
+				a.events.drop(sub)
+			}
+			return false
+		}
+		sub.active(time.Now())
+		return true
+	}
+
+	heartbeat := time.NewTicker(a.events.heartbeatInterval())
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.done:
+			return
+		case <-heartbeat.C:
+			if !send(": heartbeat\n\n") {
+				return
+			}
+		case event := <-sub.events:
+			if !send("event: %s\ndata: %s\n\n", event.kind, event.data) {
+				return
+			}
+			if sub.watching && event.endsItem() {
+				return
+			}
+		}
+	}
+}
+
+func (a *App) getItem(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	item, ok := a.storeFor(r.Context()).Get(id)
+	if !ok {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+
+	respond(w, r, http.StatusOK, newItemResponse(item))
+}
+
+func (a *App) getItemBySlug(w http.ResponseWriter, r *http.Request) {
+	item, ok := a.storeFor(r.Context()).GetBySlug(chi.URLParam(r, "slug"))
+	if !ok {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+
+	respond(w, r, http.StatusOK, newItemResponse(item))
+}
+
+// itemCompletedAt answers with just an item's completedAt, for clients that
+// need nothing else, or 204 while the item is pending.
+func (a *App) itemCompletedAt(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	item, ok := a.storeFor(r.Context()).Get(id)
+	if !ok {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	if item.CompletedAt == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]Timestamp{"completedAt": Timestamp(*item.CompletedAt)})
+}
+
+// itemBlockers lists the items an item is blocked by, done or not.
+func (a *App) itemBlockers(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	blockers, err := a.storeFor(r.Context()).Blockers(id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	respond(w, r, http.StatusOK, newXMLList("items", newItemResponses(blockers)))
+}
+
+type createItemRequest struct {
+	Name            string     `json:"name"`
+	Completed       bool       `json:"completed"`
+	EstimateMinutes int        `json:"estimateMinutes"`
+	Tags            []string   `json:"tags"`
+	DueDate         *time.Time `json:"dueDate"`
+	ExpiresAt       *time.Time `json:"expiresAt"`
+	BlockedBy       []int      `json:"blockedBy"`
+}
+
+func (req createItemRequest) input() ItemInput {
+	return ItemInput{
+		Name:            req.Name,
+		Completed:       req.Completed,
+		EstimateMinutes: req.EstimateMinutes,
+		Tags:            req.Tags,
+		DueDate:         req.DueDate,
+		ExpiresAt:       req.ExpiresAt,
+		BlockedBy:       req.BlockedBy,
+	}
+}
+
+// createItem takes one item or, like POST /items/bulk, an array of them.
+func (a *App) createItem(w http.ResponseWriter, r *http.Request) {
+	if start, err := peekJSONStart(r); err == nil {
+		switch start {
+		case '{':
+		case '[':
+			a.bulkCreateItems(w, r)
+			return
+		default:
+			http.Error(w, "Request body must be a JSON object or an array of objects", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var req createItemRequest
+	if !decodeValidated(w, r, a.schemas["item-create"], &req) {
+		return
+	}
+
+	item, err := a.storeFor(r.Context()).Create(req.input())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeItem(w, r, http.StatusCreated, itemLocation(r, item.ID), item)
+	a.events.publish("created", newItemResponse(item))
+}
+
+// peekJSONStart returns the first non-whitespace byte of the request body,
+// leaving the body readable from that byte on.
+func peekJSONStart(r *http.Request) (byte, error) {
+	br := bufio.NewReader(r.Body)
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{br, r.Body}
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
+
+// bulkResult reports the outcome of one entry of a partial bulk create.
+type bulkResult struct {
+	Index      int         `json:"index"`
+	Status     int         `json:"status"`
+	ID         int         `json:"id,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// validateItemEntry checks one entry of a bulk request against the create
+// schema. It fails when the entry isn't an item at all or sets id, and returns
+// the violations when it breaks the schema.
+func (a *App) validateItemEntry(entry json.RawMessage) (createItemRequest, []Violation, error) {
+	var req createItemRequest
+	if setsID(entry) {
+		return req, nil, errIDReadOnly
+	}
+	violations, err := validateBody(a.schemas["item-create"], entry)
+	if err == nil && len(violations) == 0 {
+		err = json.Unmarshal(entry, &req)
+	}
+	if err != nil {
+		return req, nil, errors.New("invalid item")
+	}
+	return req, violations, nil
+}
+
+// validateItems checks a bulk create request row by row without creating
+// anything. It answers 200 even when rows are invalid, unless ?strict=true
+// asks for a 422 in that case.
+func (a *App) validateItems(w http.ResponseWriter, r *http.Request) {
+	strict := r.URL.Query().Get("strict") == "true"
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(body, &entries); err != nil {
+		http.Error(w, "Request body must be a JSON array of items", http.StatusBadRequest)
+		return
+	}
+	if len(entries) == 0 || len(entries) > maxBulkCreate {
+		http.Error(w, fmt.Sprintf("Request must contain between 1 and %d items", maxBulkCreate), http.StatusBadRequest)
+		return
+	}
+
+	type rowResult struct {
+		Row        int         `json:"row"`
+		Valid      bool        `json:"valid"`
+		Error      string      `json:"error,omitempty"`
+		Violations []Violation `json:"violations,omitempty"`
+	}
+	results := make([]rowResult, len(entries))
+	invalid := 0
+	for i, entry := range entries {
+		results[i].Row = i
+		_, violations, err := a.validateItemEntry(entry)
+		switch {
+		case err != nil:
+			results[i].Error = err.Error()
+		case len(violations) > 0:
+			results[i].Error = "validation failed"
+			results[i].Violations = violations
+		default:
+			results[i].Valid = true
+			continue
+		}
+		invalid++
+	}
+
+	status := http.StatusOK
+	if strict && invalid > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, map[string]any{
+		"valid":   len(entries) - invalid,
+		"invalid": invalid,
+		"results": results,
+	})
+}
+
+// bulkCreateItems creates every item in the request or none of them. With
+// mode=partial it instead creates each valid entry and reports per-entry
+// results with 207 Multi-Status.
+func (a *App) bulkCreateItems(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	switch mode {
+	case "", "atomic":
+	case "partial":
+		a.bulkCreatePartial(w, r)
+		return
+	default:
+		http.Error(w, "mode must be atomic or partial", http.StatusBadRequest)
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	if i := entrySettingID(body); i >= 0 {
+		http.Error(w, fmt.Sprintf("item %d: %v", i, errIDReadOnly), http.StatusBadRequest)
+		return
+	}
+	if !validateRaw(w, a.schemas["item-bulk-create"], body) {
+		return
+	}
+	var reqs []createItemRequest
+	if err := json.Unmarshal(body, &reqs); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	inputs := make([]ItemInput, len(reqs))
+	for i, req := range reqs {
+		inputs[i] = req.input()
+	}
+	items, err := a.storeFor(r.Context()).CreateMany(inputs)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// A 201 names one resource, so Location points at the first new item; the
+	// body lists them all.
+	collection := r.URL.Path
+	if path.Base(collection) == "bulk" {
+		collection = path.Dir(collection)
+	}
+	w.Header().Set("Location", path.Join(collection, strconv.Itoa(items[0].ID)))
+	writeJSON(w, http.StatusCreated, newItemResponses(items))
+	for _, item := range items {
+		a.events.publish("created", newItemResponse(item))
+	}
+}
+
+func (a *App) bulkCreatePartial(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(body, &entries); err != nil {
+		http.Error(w, "Request body must be a JSON array of items", http.StatusBadRequest)
+		return
+	}
+	if len(entries) == 0 || len(entries) > maxBulkCreate {
+		http.Error(w, fmt.Sprintf("Request must contain between 1 and %d items", maxBulkCreate), http.StatusBadRequest)
+		return
+	}
+
+	results, created := a.createEntries(r, entries)
+	writeJSON(w, http.StatusMultiStatus, map[string]any{
+		"created": created,
+		"failed":  len(entries) - created,
+		"results": results,
+	})
+}
+
+// createEntries creates each valid entry on its own, returning a result per
+// entry and how many were created.
+func (a *App) createEntries(r *http.Request, entries []json.RawMessage) ([]bulkResult, int) {
+	results := make([]bulkResult, len(entries))
+	created := 0
+	for i, entry := range entries {
+		results[i].Index = i
+
+		req, violations, err := a.validateItemEntry(entry)
+		if err != nil {
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = err.Error()
+			continue
+		}
+		if len(violations) > 0 {
+			results[i].Status = http.StatusUnprocessableEntity
+			results[i].Error = "validation failed"
+			results[i].Violations = violations
+			continue
+		}
+
+		item, err := a.storeFor(r.Context()).Create(req.input())
+		if err != nil {
+			results[i].Status = storeErrorStatus(err)
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Status = http.StatusCreated
+		results[i].ID = item.ID
+		created++
+		a.events.publish("created", newItemResponse(item))
+	}
+	return results, created
+}
+
+func (a *App) bulkUpdateItems(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Filter struct {
+			Completed *bool  `json:"completed"`
+			Tag       string `json:"tag"`
+		} `json:"filter"`
+		Patch struct {
+			Completed       *bool     `json:"completed"`
+			EstimateMinutes *int      `json:"estimateMinutes"`
+			Tags            *[]string `json:"tags"`
+		} `json:"patch"`
+	}
+
+	if !decodeValidated(w, r, a.schemas["item-bulk-update"], &req) {
+		return
+	}
+
+	updated, err := a.storeFor(r.Context()).BulkUpdate(Filter{
+		Completed: req.Filter.Completed,
+		Tag:       req.Filter.Tag,
+	}, ItemUpdate{
+		Completed:       req.Patch.Completed,
+		EstimateMinutes: req.Patch.EstimateMinutes,
+		Tags:            req.Patch.Tags,
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"updated": updated})
+	if updated > 0 {
+		a.events.publish("bulk-updated", map[string]int{"updated": updated})
+	}
+}
+
+func (a *App) bulkTagItems(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs    []int    `json:"ids"`
+		Add    []string `json:"add"`
+		Remove []string `json:"remove"`
+	}
+
+	if !decodeValidated(w, r, a.schemas["item-bulk-tag"], &req) {
+		return
+	}
+
+	tagged, err := a.storeFor(r.Context()).BulkTag(req.IDs, req.Add, req.Remove)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	items := newItemResponses(tagged)
+	writeJSON(w, http.StatusOK, items)
+	if len(items) > 0 {
+		a.events.publish("bulk-tagged", map[string]int{"tagged": len(items)})
+	}
+}
+
+func (a *App) replaceItem(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Name            *string    `json:"name"`
+		Completed       *bool      `json:"completed"`
+		EstimateMinutes *int       `json:"estimateMinutes"`
+		Tags            *[]string  `json:"tags"`
+		DueDate         *time.Time `json:"dueDate"`
+		ExpiresAt       *time.Time `json:"expiresAt"`
+		BlockedBy       *[]int     `json:"blockedBy"`
+	}
+
+	if !decodeValidated(w, r, a.schemas["item-update"], &req) {
+		return
+	}
+
+	// PUT replaces the item, so it must at least name it; use PATCH for
+	// partial updates.
+	if req.Name == nil {
+		http.Error(w, "name is required for PUT", http.StatusBadRequest)
+		return
+	}
+
+	// If-None-Match: * matches any current item, so the PUT only goes ahead
+	// as a create while the ID is free.
+	if strings.TrimSpace(r.Header.Get("If-None-Match")) == "*" {
+		if id == 0 {
+			http.Error(w, "ID must be positive to create an item", http.StatusBadRequest)
+			return
+		}
+		in := ItemInput{Name: *req.Name, DueDate: req.DueDate, ExpiresAt: req.ExpiresAt}
+		if req.Completed != nil {
+			in.Completed = *req.Completed
+		}
+		if req.EstimateMinutes != nil {
+			in.EstimateMinutes = *req.EstimateMinutes
+		}
+		if req.Tags != nil {
+			in.Tags = *req.Tags
+		}
+		if req.BlockedBy != nil {
+			in.BlockedBy = *req.BlockedBy
+		}
+
+		item, err := a.storeFor(r.Context()).CreateWithID(id, in)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeItem(w, r, http.StatusCreated, r.URL.Path, item)
+		a.events.publish("created", newItemResponse(item))
+		return
+	}
+
+	// Fields the body leaves out go back to their defaults, as they would on
+	// a create.
+	item, changed, err := a.storeFor(r.Context()).Patch(id, nil, func(current Item) (Item, error) {
+		current.Name = *req.Name
+		current.Completed = req.Completed != nil && *req.Completed
+		current.EstimateMinutes, current.Tags, current.BlockedBy = 0, nil, nil
+		if req.EstimateMinutes != nil {
+			current.EstimateMinutes = *req.EstimateMinutes
+		}
+		if req.Tags != nil {
+			current.Tags = *req.Tags
+		}
+		if req.BlockedBy != nil {
+			current.BlockedBy = *req.BlockedBy
+		}
+		current.DueDate, current.ExpiresAt = req.DueDate, req.ExpiresAt
+		return current, nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeItem(w, r, http.StatusOK, r.URL.Path, item)
+	if changed {
+		a.events.publish("updated", newItemResponse(item))
+	}
+}
+
+func (a *App) completeItem(w http.ResponseWriter, r *http.Request) {
+	a.setCompleted(w, r, true)
+}
+
+func (a *App) uncompleteItem(w http.ResponseWriter, r *http.Request) {
+	a.setCompleted(w, r, false)
+}
+
+// setCompleted is idempotent: an item already in the requested state is
+// returned unchanged.
+func (a *App) setCompleted(w http.ResponseWriter, r *http.Request, completed bool) {
+	id, err := parseID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	item, changed, err := a.storeFor(r.Context()).Update(id, ItemUpdate{Completed: &completed})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// The item's URL is the parent of /complete or /uncomplete.
+	writeItem(w, r, http.StatusOK, path.Dir(r.URL.Path), item)
+	if changed {
+		a.events.publish("updated", newItemResponse(item))
+	}
+}
+
+func (a *App) patchItem(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var apply func(doc []byte) ([]byte, error)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json-patch+json":
+		if !validateRaw(w, a.schemas["item-json-patch"], body) {
+			return
+		}
+		patch, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			http.Error(w, "Invalid JSON Patch document", http.StatusBadRequest)
+			return
+		}
+		if field, ok := patchTouchesReadOnly(patch); ok {
+			rejectReadOnly(w, field)
+			return
+		}
+		apply = patch.Apply
+	case "application/merge-patch+json", "application/json", "":
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+			http.Error(w, "Invalid merge patch document", http.StatusBadRequest)
+			return
+		}
+		for _, field := range readOnlyFields {
+			if _, ok := fields[field]; ok {
+				rejectReadOnly(w, field)
+				return
+			}
+		}
+		if !validateRaw(w, a.schemas["item-merge-patch"], body) {
+			return
+		}
+		apply = func(doc []byte) ([]byte, error) {
+			return jsonpatch.MergePatch(doc, body)
+		}
+	default:
+		http.Error(w, "Unsupported patch media type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	changedOnly, err := wantsChangedOnly(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var expected map[string]any
+	if v := r.Header.Get("X-Expected-Values"); v != "" {
+		if err := json.Unmarshal([]byte(v), &expected); err != nil || expected == nil {
+			http.Error(w, "X-Expected-Values must be a JSON object of field values", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var before Item
+	item, changed, err := a.storeFor(r.Context()).Patch(id, expected, func(current Item) (Item, error) {
+		before = current
+		return applyItemPatch(current, apply)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if changedOnly {
+		changes, err := changedFields(&before, item)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !r.URL.Query().Has("changedOnly") {
+			w.Header().Set("Preference-Applied", "return=changed")
+		}
+		writeJSON(w, http.StatusOK, changes)
+	} else {
+		writeItem(w, r, http.StatusOK, r.URL.Path, item)
+	}
+	// A patch that changed nothing isn't news to subscribers.
+	if changed {
+		a.events.publish("updated", newItemResponse(item))
+	}
+}
+
+func (a *App) deleteItem(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	returnItem, err := wantsDeletedItem(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if pref := preferredReturn(r); pref != "" && !r.URL.Query().Has("return") {
+		w.Header().Set("Preference-Applied", "return="+pref)
+	}
+	// Refuse before deleting, not after, when the item couldn't be sent back.
+	if _, ok := negotiate(r); returnItem && !ok {
+		writeNotAcceptable(w)
+		return
+	}
+
+	item, ok := a.storeFor(r.Context()).Delete(id)
+	if !ok {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+
+	if returnItem {
+		respond(w, r, http.StatusOK, newItemResponse(item))
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	a.events.publish("deleted", map[string]int{"id": id})
+}
+
+// wantsDeletedItem reports whether a DELETE asked for the removed item back,
+// via ?return=true or Prefer: return=representation. The query parameter
+// takes precedence.
+func wantsDeletedItem(r *http.Request) (bool, error) {
+	if v := r.URL.Query().Get("return"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, errors.New("return must be true or false")
+		}
+		return b, nil
+	}
+	return preferredReturn(r) == "representation", nil
+}
+
+// wantsChangedOnly reports whether a PATCH should answer with only the fields
+// it changed, asked for with ?changedOnly=true or Prefer: return=changed.
+func wantsChangedOnly(r *http.Request) (bool, error) {
+	if v := r.URL.Query().Get("changedOnly"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, errors.New("changedOnly must be true or false")
+		}
+		return b, nil
+	}
+	return strings.EqualFold(preferences(r)["return"], "changed"), nil
+}
+
+// parseID reads the {id} URL parameter, telling malformed, out-of-range and
+// negative values apart so clients get a useful message.
+func parseID(r *http.Request) (int, error) {
+	raw := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(raw)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, fmt.Errorf("ID %s is out of range", raw)
+		}
+		return 0, fmt.Errorf("ID %q is not a number", raw)
+	}
+	if id < 0 {
+		return 0, fmt.Errorf("ID must not be negative")
+	}
+	return id, nil
+}
+
+// itemLocation builds the URL of an item relative to the collection the
+// request was made against, so any prefix the router is mounted under is kept.
+func itemLocation(r *http.Request, id int) string {
+	return path.Join(r.URL.Path, strconv.Itoa(id))
+}
+
+// filterParams are the query parameters parseFilter understands, including
+// the After and Before of every field with a rangeParam.
+var filterParams = func() []string {
+	params := []string{"completed", "tag", "q", "in"}
+	for _, f := range itemFields {
+		if f.rangeParam != "" {
+			params = append(params, f.rangeParam+"After", f.rangeParam+"Before")
+		}
+	}
+	return params
+}()
+
+// listParams are the query parameters GET /items understands.
+var listParams = append([]string{"limit", "offset", "after", "sort", "order", "locale", "tz"}, filterParams...)
+
+// unknownParams lists, sorted, the query parameters of r that aren't in known.
+func unknownParams(r *http.Request, known []string) []string {
+	var unknown []string
+	for name := range r.URL.Query() {
+		if !slices.Contains(known, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	slices.Sort(unknown)
+	return unknown
+}
+
+// parseFilter reads the list filters from the query string. Every filter
+// given must match, so they narrow the list in any combination.
+func parseFilter(r *http.Request) (Filter, error) {
+	query := r.URL.Query()
+	filter := Filter{
+		Tag:   query.Get("tag"),
+		Query: query.Get("q"),
+	}
+
+	if v := query.Get("completed"); v != "" {
+		completed, err := strconv.ParseBool(v)
+		if err != nil {
+			return Filter{}, fmt.Errorf("completed must be true or false")
+		}
+		filter.Completed = &completed
+	}
+	if v := query.Get("in"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			field := SearchField(strings.ToLower(strings.TrimSpace(name)))
+			if !slices.Contains(searchFields, field) {
+				return Filter{}, fmt.Errorf("in must list fields from: %s", joinFields(searchFields))
+			}
+			if !slices.Contains(filter.QueryIn, field) {
+				filter.QueryIn = append(filter.QueryIn, field)
+			}
+		}
+	}
+	for _, f := range itemFields {
+		if f.rangeParam == "" {
+			continue
+		}
+		var bounds TimeRange
+		for name, dst := range map[string]*time.Time{
+			f.rangeParam + "After":  &bounds.After,
+			f.rangeParam + "Before": &bounds.Before,
+		} {
+			if v := query.Get(name); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					return Filter{}, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+				}
+				*dst = t
+			}
+		}
+		if bounds != (TimeRange{}) {
+			if filter.Ranges == nil {
+				filter.Ranges = map[string]TimeRange{}
+			}
+			filter.Ranges[f.name] = bounds
+		}
+	}
+	return filter, nil
+}
+
+func joinFields(fields []SearchField) string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = string(f)
+	}
+	return strings.Join(names, ", ")
+}
+
+// filterKey canonicalizes the filter parameters of r so equivalent queries
+// share a key.
+func filterKey(r *http.Request) string {
+	query := r.URL.Query()
+	values := url.Values{}
+	for _, name := range filterParams {
+		if v := query.Get(name); v != "" {
+			values.Set(name, v)
+		}
+	}
+	return values.Encode()
+}
+
+// listETag is a weak ETag for the list a request key gives at version. The
+// same request against the same version always gives the same list, so the
+// items themselves needn't be hashed. Modified keeps revisions from an earlier
+// run of the server from matching.
+func listETag(version Version, key string) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%d/%s", version.Revision, version.Modified.UnixNano(), key)
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// parseTimeZone reads the tz query parameter, an IANA time zone, returning
+// fallback when it is absent.
+func parseTimeZone(r *http.Request, fallback *time.Location) (*time.Location, error) {
+	v := r.URL.Query().Get("tz")
+	if v == "" {
+		return fallback, nil
+	}
+	loc, err := time.LoadLocation(v)
+	if err != nil {
+		return nil, errors.New("tz must be an IANA time zone such as Europe/Paris")
+	}
+	return loc, nil
+}
+
+// parseCursor reads the after query parameter, reporting whether the
+// request asked for cursor rather than offset pagination.
+func parseCursor(r *http.Request) (after int, ok bool, err error) {
+	query := r.URL.Query()
+	if !query.Has("after") {
+		return 0, false, nil
+	}
+	if query.Has("offset") {
+		return 0, false, fmt.Errorf("use either offset or after, not both")
+	}
+	after, err = strconv.Atoi(query.Get("after"))
+	if err != nil || after < 0 {
+		return 0, false, fmt.Errorf("after must be a non-negative item ID")
+	}
+	return after, true, nil
+}
+
+// pageRels is the order page links are listed in the Link header.
+var pageRels = []string{"first", "prev", "next", "last"}
+
+// setPageLinks writes an RFC 8288 Link header pointing at other pages of the
+// current request. Each link keeps the request's other query parameters and
+// replaces the paging ones with those in links, keyed by relation.
+func setPageLinks(w http.ResponseWriter, r *http.Request, limit int, links map[string]url.Values) {
+	var parts []string
+	for _, rel := range pageRels {
+		paging, ok := links[rel]
+		if !ok {
+			continue
+		}
+		query := r.URL.Query()
+		query.Del("offset")
+		query.Del("after")
+		query.Set("limit", strconv.Itoa(limit))
+		for k, v := range paging {
+			query[k] = v
+		}
+		u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		parts = append(parts, fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel))
+	}
+	w.Header().Set("Link", strings.Join(parts, ", "))
+}
+
+// parsePage reads the offset and limit query parameters, falling back to
+// defaultSize and clamping the limit to maxSize.
+func parsePage(r *http.Request, defaultSize, maxSize int) (offset, limit int, err error) {
+	limit = defaultSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("limit must be a positive integer")
+		}
+	}
+	if limit > maxSize {
+		limit = maxSize
+	}
+
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	return offset, limit, nil
+}
@@ -0,0 +1,892 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCreateSetsLocation(t *testing.T) {
+	_, h := newTestApp(t, nil)
+
+	tests := []struct {
+		name, target, body string
+	}{
+		{"single", "/items", `{"name":"Write tests"}`},
+		{"bulk", "/items/bulk", `[{"name":"First"},{"name":"Second"}]`},
+		{"array on collection", "/items", `[{"name":"Third"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(h, http.MethodPost, tt.target, "application/json", tt.body)
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body)
+			}
+
+			var ids []struct{ ID int }
+			if tt.body[0] == '[' {
+				err := json.Unmarshal(rec.Body.Bytes(), &ids)
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				var one struct{ ID int }
+				if err := json.Unmarshal(rec.Body.Bytes(), &one); err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, one)
+			}
+			if want := fmt.Sprintf("/items/%d", ids[0].ID); rec.Header().Get("Location") != want {
+				t.Errorf("Location = %q, want %q", rec.Header().Get("Location"), want)
+			}
+		})
+	}
+}
+
+func TestBodySettingIDIsRejected(t *testing.T) {
+	_, h := newTestApp(t, nil)
+
+	tests := []struct {
+		name, method, target, contentType, body string
+	}{
+		{"create", http.MethodPost, "/items", "application/json", `{"id":5,"name":"x"}`},
+		{"replace", http.MethodPut, "/items/1", "application/json", `{"id":5,"name":"x","completed":false}`},
+		{"merge patch", http.MethodPatch, "/items/1", "application/merge-patch+json", `{"id":5}`},
+		{"json patch", http.MethodPatch, "/items/1", "application/json-patch+json", `[{"op":"replace","path":"/id","value":5}]`},
+		{"bulk", http.MethodPost, "/items/bulk", "application/json", `[{"name":"x"},{"id":5,"name":"y"}]`},
+		{"array on collection", http.MethodPost, "/items", "application/json", `[{"id":5,"name":"x"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(h, tt.method, tt.target, tt.contentType, tt.body)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+
+	rec := do(h, http.MethodGet, "/items", "", "")
+	var items []struct{ ID int }
+	if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+		t.Fatalf("decoding list: %v", err)
+	}
+	if len(items) != len(seedItems) {
+		t.Errorf("%d items after rejected writes, want %d", len(items), len(seedItems))
+	}
+}
+
+func TestPartialBulkRejectsEntrySettingID(t *testing.T) {
+	_, h := newTestApp(t, nil)
+
+	rec := do(h, http.MethodPost, "/items/bulk?mode=partial", "application/json",
+		`[{"name":"x"},{"id":5,"name":"y"}]`)
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want 207: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Results []struct {
+			Status int
+			Error  string
+		}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(resp.Results) != 2 {
+		t.Fatalf("%d results, want 2", len(resp.Results))
+	}
+	if got := resp.Results[0].Status; got != http.StatusCreated {
+		t.Errorf("entry 0 status = %d, want 201", got)
+	}
+	if got := resp.Results[1]; got.Status != http.StatusBadRequest || got.Error != errIDReadOnly.Error() {
+		t.Errorf("entry 1 = %+v, want 400 %q", got, errIDReadOnly)
+	}
+}
+
+func TestOversizedBodyIsRejected(t *testing.T) {
+	_, h := newTestApp(t, nil)
+	defer func(n int64) { maxBodyBytes = n }(maxBodyBytes)
+	maxBodyBytes = 64
+
+	fits := `{"name":"` + strings.Repeat("a", 40) + `"}`
+	if rec := do(h, http.MethodPost, "/items", "application/json", fits); rec.Code != http.StatusCreated {
+		t.Fatalf("status for a %d-byte body = %d, want 201: %s", len(fits), rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		name, method, target, contentType string
+	}{
+		{"create", http.MethodPost, "/items", "application/json"},
+		{"replace", http.MethodPut, "/items/1", "application/json"},
+		{"merge patch", http.MethodPatch, "/items/1", "application/merge-patch+json"},
+		{"bulk", http.MethodPost, "/items/bulk", "application/json"},
+	}
+	big := `{"name":"` + strings.Repeat("a", 100) + `"}`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := big
+			if tt.name == "bulk" {
+				body = "[" + big + "]"
+			}
+			rec := do(h, tt.method, tt.target, tt.contentType, body)
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("status = %d, want 413: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+// streamRecorder is a ResponseWriter that may or may not be an http.Flusher,
+// and whose writes can be made to fail.
+type streamRecorder struct {
+	header http.Header
+	code   int
+	failed error
+}
+
+func (s *streamRecorder) Header() http.Header { return s.header }
+func (s *streamRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+}
+func (s *streamRecorder) Write(p []byte) (int, error) {
+	s.WriteHeader(http.StatusOK)
+	if s.failed != nil {
+		return 0, s.failed
+	}
+	return len(p), nil
+}
+
+type flushingStreamRecorder struct{ *streamRecorder }
+
+func (flushingStreamRecorder) Flush() {}
+
+func TestEventsNeedAFlusher(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+
+	w := &streamRecorder{header: http.Header{}}
+	app.itemEvents(w, httptest.NewRequest(http.MethodGet, "/items/events", nil))
+	if w.code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.code)
+	}
+	if ct := w.header.Get("Content-Type"); strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("Content-Type = %q on the error", ct)
+	}
+}
+
+func TestEventsStopOnWriteError(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+
+	w := flushingStreamRecorder{&streamRecorder{header: http.Header{}, failed: errors.New("connection reset")}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.itemEvents(w, httptest.NewRequest(http.MethodGet, "/items/events", nil))
+	}()
+
+	// Keep publishing until the stream has subscribed, hit the failing write
+	// and returned.
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+			app.events.publish("created", map[string]int{"id": 1})
+		case <-timeout:
+			t.Fatal("stream kept running after a failed write")
+		}
+	}
+}
+
+// startStream runs the event stream handler on a recorder that never fails
+// until ctx is done, and waits for it to subscribe.
+func startStream(t *testing.T, app *App, ctx context.Context) (sub *subscriber, done <-chan struct{}) {
+	t.Helper()
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		w := flushingStreamRecorder{&streamRecorder{header: http.Header{}}}
+		app.itemEvents(w, httptest.NewRequest(http.MethodGet, "/items/events", nil).WithContext(ctx))
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for app.events.count() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("stream never subscribed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	app.events.mu.Lock()
+	for s := range app.events.subs {
+		sub = s
+	}
+	app.events.mu.Unlock()
+	return sub, finished
+}
+
+func TestEventsStopWhenClientLeaves(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, done := startStream(t, app, ctx)
+
+	// Mid-stream: an event has gone out before the client leaves.
+	app.events.publish("created", map[string]int{"id": 1})
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler kept running after the request was cancelled")
+	}
+	select {
+	case <-sub.done:
+	default:
+		t.Error("subscriber still registered after the handler returned")
+	}
+	if n := app.events.count(); n != 0 {
+		t.Errorf("%d subscribers left, want 0", n)
+	}
+}
+
+// TestReaperClosesSilentStream stands in for a connection that died without
+// the server seeing it: no write to it has succeeded for longer than
+// SSE_IDLE_TIMEOUT, but the request context is still live.
+func TestReaperClosesSilentStream(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	sub, done := startStream(t, app, context.Background())
+
+	sub.lastActive.Store(time.Now().Add(-2 * app.events.idleTimeout).UnixNano())
+	app.events.reap(time.Now())
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler kept running after its subscriber was reaped")
+	}
+	if n := app.events.count(); n != 0 {
+		t.Errorf("%d subscribers left, want 0", n)
+	}
+	if n := app.events.reaped.Load(); n != 1 {
+		t.Errorf("reaped = %d, want 1", n)
+	}
+}
+
+// queryCountingStore counts the list queries that reach the store.
+type queryCountingStore struct {
+	ItemStore
+	queries atomic.Int64
+}
+
+func (s *queryCountingStore) Query(filter Filter) []*Item {
+	s.queries.Add(1)
+	return s.ItemStore.Query(filter)
+}
+
+// BenchmarkListCoalescing sends the same sorted list query from every
+// goroutine at once. Requests that overlap share one store query and
+// encoding, so queries/op stays well below 1 under contention.
+func BenchmarkListCoalescing(b *testing.B) {
+	store := &queryCountingStore{ItemStore: newStoreOf(b, 1000)}
+	_, h := newTestAppOn(b, nil, store)
+
+	b.ReportAllocs()
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if rec := do(h, http.MethodGet, "/items?sort=name&limit=100", "", ""); rec.Code != http.StatusOK {
+				b.Errorf("status = %d", rec.Code)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(store.queries.Load())/float64(b.N), "queries/op")
+}
+
+func TestListETagRevalidation(t *testing.T) {
+	_, h := newTestApp(t, nil)
+
+	first := do(h, http.MethodGet, "/items", "", "")
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("list response has no ETag")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("replayed ETag got %d, want 304", rec.Code)
+	}
+
+	if rec := do(h, http.MethodPatch, "/items/1", "application/merge-patch+json", `{"name":"Renamed"}`); rec.Code != http.StatusOK {
+		t.Fatalf("patch status = %d: %s", rec.Code, rec.Body)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/items", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Renamed") {
+		t.Fatalf("old ETag after a write got %d: %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("ETag") == etag {
+		t.Error("ETag unchanged after a write")
+	}
+}
+
+// TestListAfterWriteIsFresh has every goroutine rename its own item and then
+// list, while the others do the same. A list that joined a query started
+// before the rename would miss it, yet carry the post-write ETag.
+func TestListAfterWriteIsFresh(t *testing.T) {
+	_, h := newTestApp(t, nil)
+
+	const writers, rounds = 8, 25
+	ids := make([]int, writers)
+	for i := range ids {
+		rec := do(h, http.MethodPost, "/items", "application/json", fmt.Sprintf(`{"name":"writer %d"}`, i))
+		var item struct{ ID int }
+		if err := json.Unmarshal(rec.Body.Bytes(), &item); err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = item.ID
+	}
+
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := range rounds {
+				name := fmt.Sprintf("writer %d round %d", i, round)
+				rec := do(h, http.MethodPatch, fmt.Sprintf("/items/%d", id), "application/merge-patch+json", fmt.Sprintf(`{"name":%q}`, name))
+				if rec.Code != http.StatusOK {
+					t.Errorf("patch status = %d: %s", rec.Code, rec.Body)
+					return
+				}
+				rec = do(h, http.MethodGet, "/items?limit=100", "", "")
+				if !strings.Contains(rec.Body.String(), name) {
+					t.Errorf("list after renaming to %q missed it (ETag %s)", name, rec.Header().Get("ETag"))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestUpdateRacingDeleteNeverResurrects patches and deletes each item at
+// once. Whichever wins, the item ends up gone and no "updated" event for it
+// follows its "deleted" one.
+func TestUpdateRacingDeleteNeverResurrects(t *testing.T) {
+	app, h := newTestApp(t, nil)
+	sub := app.events.subscribe()
+	defer app.events.unsubscribe(sub)
+
+	var events []itemEvent
+	stop, collected := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(collected)
+		for {
+			select {
+			case e := <-sub.events:
+				events = append(events, e)
+			case <-stop:
+				for {
+					select {
+					case e := <-sub.events:
+						events = append(events, e)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	const rounds = 50
+	for round := range rounds {
+		rec := do(h, http.MethodPost, "/items", "application/json", fmt.Sprintf(`{"name":"racer %d"}`, round))
+		var item struct{ ID int }
+		if err := json.Unmarshal(rec.Body.Bytes(), &item); err != nil {
+			t.Fatal(err)
+		}
+		target := fmt.Sprintf("/items/%d", item.ID)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			rec := do(h, http.MethodPatch, target, "application/merge-patch+json", `{"completed":true}`)
+			if rec.Code != http.StatusOK && rec.Code != http.StatusNotFound {
+				t.Errorf("patch racing delete got %d: %s", rec.Code, rec.Body)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if rec := do(h, http.MethodDelete, target, "", ""); rec.Code != http.StatusNoContent {
+				t.Errorf("delete racing patch got %d: %s", rec.Code, rec.Body)
+			}
+		}()
+		wg.Wait()
+
+		if rec := do(h, http.MethodGet, target, "", ""); rec.Code != http.StatusNotFound {
+			t.Fatalf("item %d after the race got %d, want 404", item.ID, rec.Code)
+		}
+	}
+	close(stop)
+	<-collected
+
+	deleted := make(map[int]bool)
+	for _, e := range events {
+		switch {
+		case !e.hasItem:
+		case e.kind == "deleted":
+			deleted[e.item] = true
+		case e.kind == "updated" && deleted[e.item]:
+			t.Errorf("updated event for item %d after it was deleted", e.item)
+		}
+	}
+	if len(deleted) != rounds {
+		t.Errorf("%d deleted events, want %d", len(deleted), rounds)
+	}
+}
+
+func TestReplaceResetsLeftOutFields(t *testing.T) {
+	_, h := newTestApp(t, nil)
+	rec := do(h, http.MethodPatch, "/items/1", "application/merge-patch+json",
+		`{"completed":true,"estimateMinutes":30,"tags":["work"],"dueDate":"2030-01-01T00:00:00Z","blockedBy":[2]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("patch status = %d: %s", rec.Code, rec.Body)
+	}
+
+	for _, body := range []string{"", `{}`, `{"completed":true}`} {
+		rec := do(h, http.MethodPut, "/items/1", "application/json", body)
+		if rec.Code != http.StatusBadRequest && rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("PUT %q status = %d, want 400 or 422: %s", body, rec.Code, rec.Body)
+		}
+	}
+	if rec := do(h, http.MethodGet, "/items/1", "", ""); !strings.Contains(rec.Body.String(), `"estimateMinutes":30`) {
+		t.Fatalf("a rejected PUT changed the item: %s", rec.Body)
+	}
+
+	rec = do(h, http.MethodPut, "/items/1", "application/json", `{"name":"Only a name"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("name-only PUT status = %d: %s", rec.Code, rec.Body)
+	}
+	var item map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &item); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"name": "Only a name", "completed": false, "estimateMinutes": 0.0, "blocked": false}
+	for field, v := range want {
+		if item[field] != v {
+			t.Errorf("%s = %v after a name-only PUT, want %v", field, item[field], v)
+		}
+	}
+	for _, field := range []string{"tags", "dueDate", "blockedBy", "completedAt"} {
+		if v, ok := item[field]; ok && v != nil && fmt.Sprint(v) != "[]" {
+			t.Errorf("%s = %v after a name-only PUT, want it cleared", field, v)
+		}
+	}
+}
+
+func TestNoOpPatchPublishesNothing(t *testing.T) {
+	app, h := newTestApp(t, nil)
+	sub := app.events.subscribe()
+	defer app.events.unsubscribe(sub)
+
+	before := do(h, http.MethodGet, "/items/1", "", "")
+	rec := do(h, http.MethodPatch, "/items/1", "application/merge-patch+json", `{"name":"Learn Go","completed":false}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("patch status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec.Body.String() != before.Body.String() {
+		t.Errorf("no-op patch changed the item:\nbefore %s\nafter  %s", before.Body, rec.Body)
+	}
+	if got, want := rec.Header().Get("ETag"), before.Header().Get("ETag"); got != want {
+		t.Errorf("ETag after a no-op patch = %s, want %s", got, want)
+	}
+	select {
+	case e := <-sub.events:
+		t.Errorf("no-op patch published %q: %s", e.kind, e.data)
+	default:
+	}
+
+	// A patch that does change something still gets through.
+	do(h, http.MethodPatch, "/items/1", "application/merge-patch+json", `{"completed":true}`)
+	select {
+	case e := <-sub.events:
+		if e.kind != "updated" {
+			t.Errorf("patch published %q, want updated", e.kind)
+		}
+	default:
+		t.Error("patch that completed the item published nothing")
+	}
+}
+
+func TestPatchReturnsOnlyChangedFields(t *testing.T) {
+	_, h := newTestApp(t, nil)
+
+	for _, tt := range []struct{ name, target, prefer string }{
+		{"query", "/items/1?changedOnly=true", ""},
+		{"prefer", "/items/2", "return=changed"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, tt.target, strings.NewReader(`{"estimateMinutes":45,"completed":false}`))
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+			if tt.prefer != "" {
+				req.Header.Set("Prefer", tt.prefer)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &fields); err != nil {
+				t.Fatal(err)
+			}
+			// updatedAt changes too, unless the clock didn't move on.
+			delete(fields, "updatedAt")
+			var names []string
+			for name := range fields {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, []string{"estimateMinutes", "id"}) {
+				t.Errorf("response fields = %v, want only id and estimateMinutes: %s", names, rec.Body)
+			}
+			if string(fields["estimateMinutes"]) != "45" {
+				t.Errorf("estimateMinutes = %s, want 45", fields["estimateMinutes"])
+			}
+			if tt.prefer != "" && rec.Header().Get("Preference-Applied") != "return=changed" {
+				t.Errorf("Preference-Applied = %q, want return=changed", rec.Header().Get("Preference-Applied"))
+			}
+		})
+	}
+}
+
+func TestCompleteAndUncompleteAreIdempotent(t *testing.T) {
+	app, h := newTestApp(t, nil)
+	sub := app.events.subscribe()
+	defer app.events.unsubscribe(sub)
+
+	for _, action := range []struct {
+		path      string
+		completed bool
+	}{{"complete", true}, {"uncomplete", false}} {
+		first := do(h, http.MethodPost, "/items/1/"+action.path, "", "")
+		if first.Code != http.StatusOK {
+			t.Fatalf("%s status = %d: %s", action.path, first.Code, first.Body)
+		}
+		var item struct{ Completed bool }
+		if err := json.Unmarshal(first.Body.Bytes(), &item); err != nil {
+			t.Fatal(err)
+		}
+		if item.Completed != action.completed {
+			t.Errorf("completed after %s = %v", action.path, item.Completed)
+		}
+
+		again := do(h, http.MethodPost, "/items/1/"+action.path, "", "")
+		if again.Code != http.StatusOK || again.Body.String() != first.Body.String() {
+			t.Errorf("repeated %s = %d %s, want the same 200 %s", action.path, again.Code, again.Body, first.Body)
+		}
+		if again.Header().Get("ETag") != first.Header().Get("ETag") {
+			t.Errorf("repeated %s changed the ETag", action.path)
+		}
+
+		// Only the first call changed the item, so only it is news.
+		if e := <-sub.events; e.kind != "updated" {
+			t.Errorf("%s published %q, want updated", action.path, e.kind)
+		}
+		select {
+		case e := <-sub.events:
+			t.Errorf("repeated %s published %q", action.path, e.kind)
+		default:
+		}
+	}
+
+	for _, path := range []string{"/items/999/complete", "/items/999/uncomplete"} {
+		if rec := do(h, http.MethodPost, path, "", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("POST %s = %d, want 404", path, rec.Code)
+		}
+	}
+}
+
+// pageLinks parses a Link header into URLs keyed by relation.
+func pageLinks(t *testing.T, header string) map[string]*url.URL {
+	t.Helper()
+	links := map[string]*url.URL{}
+	for _, part := range strings.Split(header, ", ") {
+		target, params, ok := strings.Cut(part, ">; ")
+		rel, relOK := strings.CutPrefix(params, `rel="`)
+		if !ok || !relOK || !strings.HasPrefix(target, "<") {
+			t.Fatalf("malformed link %q in %q", part, header)
+		}
+		u, err := url.Parse(strings.TrimPrefix(target, "<"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		links[strings.TrimSuffix(rel, `"`)] = u
+	}
+	return links
+}
+
+func TestListLinkHeaders(t *testing.T) {
+	_, h := newTestAppOn(t, nil, newStoreOf(t, 10))
+
+	t.Run("offset", func(t *testing.T) {
+		rec := do(h, http.MethodGet, "/items?tag=bench&offset=3&limit=3", "", "")
+		links := pageLinks(t, rec.Header().Get("Link"))
+		want := map[string]string{"first": "0", "prev": "0", "next": "6", "last": "9"}
+		if len(links) != len(want) {
+			t.Fatalf("links = %v, want %v", links, want)
+		}
+		for rel, offset := range want {
+			u := links[rel]
+			if u == nil {
+				t.Errorf("no %s link", rel)
+				continue
+			}
+			q := u.Query()
+			if u.Path != "/items" || q.Get("offset") != offset || q.Get("limit") != "3" || q.Get("tag") != "bench" {
+				t.Errorf("%s link = %s, want /items at offset %s keeping limit and tag", rel, u, offset)
+			}
+		}
+
+		rec = do(h, http.MethodGet, "/items?offset=9&limit=3", "", "")
+		links = pageLinks(t, rec.Header().Get("Link"))
+		if _, ok := links["next"]; ok {
+			t.Errorf("last page has a next link: %s", rec.Header().Get("Link"))
+		}
+	})
+
+	t.Run("cursor", func(t *testing.T) {
+		// Following next from the first page visits every item once.
+		seen := map[int]bool{}
+		target := "/items?after=0&limit=4"
+		for pages := 0; target != ""; pages++ {
+			if pages > 10 {
+				t.Fatal("next links never ran out")
+			}
+			rec := do(h, http.MethodGet, target, "", "")
+			var items []struct{ ID int }
+			if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+				t.Fatal(err)
+			}
+			for _, item := range items {
+				if seen[item.ID] {
+					t.Errorf("item %d on two pages", item.ID)
+				}
+				seen[item.ID] = true
+			}
+			links := pageLinks(t, rec.Header().Get("Link"))
+			if first := links["first"]; first == nil || first.Query().Get("after") != "0" {
+				t.Errorf("first link = %v, want after=0", first)
+			}
+			if _, ok := links["last"]; ok {
+				t.Error("cursor pages have a last link")
+			}
+			target = ""
+			if next := links["next"]; next != nil {
+				target = next.String()
+			}
+		}
+		if len(seen) != 10 {
+			t.Errorf("following next links saw %d items, want 10", len(seen))
+		}
+	})
+}
+
+func TestConditionalCreate(t *testing.T) {
+	_, h := newTestApp(t, nil)
+	put := func(target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-None-Match", "*")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Item 1 exists, so the create is refused and the item left alone.
+	if rec := put("/items/1", `{"name":"Taken"}`); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("create over an existing item = %d, want 412: %s", rec.Code, rec.Body)
+	}
+	if rec := do(h, http.MethodGet, "/items/1", "", ""); strings.Contains(rec.Body.String(), "Taken") {
+		t.Errorf("refused create changed the item: %s", rec.Body)
+	}
+
+	rec := put("/items/40", `{"name":"Fresh","estimateMinutes":5}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create at a free ID = %d, want 201: %s", rec.Code, rec.Body)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/items/40" {
+		t.Errorf("Location = %q, want /items/40", loc)
+	}
+	if rec := do(h, http.MethodGet, "/items/40", "", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"name":"Fresh"`) {
+		t.Errorf("GET after conditional create = %d: %s", rec.Code, rec.Body)
+	}
+
+	// Once created, the ID is taken.
+	if rec := put("/items/40", `{"name":"Again"}`); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("second conditional create = %d, want 412: %s", rec.Code, rec.Body)
+	}
+	// New IDs continue past it.
+	rec = do(h, http.MethodPost, "/items", "application/json", `{"name":"Next"}`)
+	if !strings.Contains(rec.Body.String(), `"id":41`) {
+		t.Errorf("create after the conditional create: %s, want id 41", rec.Body)
+	}
+}
+
+func TestTagLimitBoundaries(t *testing.T) {
+	// main applies MAX_TAGS and MAX_TAG_LENGTH as store options.
+	store := NewStore(WithTagLimits(3, 5))
+	store.Reset(seedItems)
+	_, h := newTestAppOn(t, nil, store)
+
+	tests := []struct {
+		name, tags string
+		status     int
+	}{
+		{"max count", `["a","b","c"]`, http.StatusOK},
+		{"one over max count", `["a","b","c","d"]`, http.StatusUnprocessableEntity},
+		{"repeats count once", `["a","A"," a ","b","c"]`, http.StatusOK},
+		{"max length", `["abcde"]`, http.StatusOK},
+		{"one over max length", `["abcdef"]`, http.StatusUnprocessableEntity},
+		{"length in characters", `["ééééé"]`, http.StatusOK},
+		{"length after trimming", `["  abcde  "]`, http.StatusOK},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			created := http.StatusCreated
+			if tt.status != http.StatusOK {
+				created = tt.status
+			}
+			rec := do(h, http.MethodPost, "/items", "application/json", fmt.Sprintf(`{"name":"tagged %d","tags":%s}`, i, tt.tags))
+			if rec.Code != created {
+				t.Errorf("create status = %d, want %d: %s", rec.Code, created, rec.Body)
+			}
+			rec = do(h, http.MethodPatch, "/items/1", "application/merge-patch+json", fmt.Sprintf(`{"tags":%s}`, tt.tags))
+			if rec.Code != tt.status {
+				t.Errorf("patch status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestCreateInEitherState(t *testing.T) {
+	_, h := newTestApp(t, nil)
+
+	tests := []struct {
+		name, body string
+		completed  bool
+	}{
+		{"omitted", `{"name":"Plan"}`, false},
+		{"false", `{"name":"Start","completed":false}`, false},
+		{"true", `{"name":"Logged work","completed":true}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(h, http.MethodPost, "/items", "application/json", tt.body)
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var item struct {
+				ID          int
+				Completed   bool
+				CompletedAt *time.Time
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &item); err != nil {
+				t.Fatal(err)
+			}
+			if item.Completed != tt.completed || (item.CompletedAt != nil) != tt.completed {
+				t.Errorf("completed = %v, completedAt = %v; want completed %v", item.Completed, item.CompletedAt, tt.completed)
+			}
+
+			for _, completed := range []bool{false, true} {
+				filter := fmt.Sprintf("/items?completed=%t", completed)
+				rec := do(h, http.MethodGet, filter, "", "")
+				if listed := strings.Contains(rec.Body.String(), fmt.Sprintf(`"id":%d,`, item.ID)); listed != (completed == tt.completed) {
+					t.Errorf("%s lists the new item: %v", filter, listed)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateAcceptsObjectOrArray(t *testing.T) {
+	_, h := newTestApp(t, nil)
+
+	tests := []struct {
+		name, body string
+		status     int
+		// shape is the first byte of a successful response.
+		shape byte
+	}{
+		{"object", `{"name":"One"}`, http.StatusCreated, '{'},
+		{"array", `[{"name":"Two"},{"name":"Three"}]`, http.StatusCreated, '['},
+		{"object after whitespace", "\n\t {\"name\":\"Four\"}", http.StatusCreated, '{'},
+		{"array after whitespace", "  \r\n[{\"name\":\"Five\"}]", http.StatusCreated, '['},
+		{"string", `"Six"`, http.StatusBadRequest, 0},
+		{"number", `7`, http.StatusBadRequest, 0},
+		{"null", `null`, http.StatusBadRequest, 0},
+		{"boolean", `true`, http.StatusBadRequest, 0},
+		{"empty", ``, http.StatusBadRequest, 0},
+		{"whitespace only", "  \n", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(h, http.MethodPost, "/items", "application/json", tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.shape != 0 && (rec.Body.Len() == 0 || rec.Body.Bytes()[0] != tt.shape) {
+				t.Errorf("response %s, want it to start with %c", rec.Body, tt.shape)
+			}
+		})
+	}
+
+	rec := do(h, http.MethodGet, "/items", "", "")
+	var items []struct{ Name string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+		t.Fatal(err)
+	}
+	if want := len(seedItems) + 5; len(items) != want {
+		t.Errorf("%d items after the creates, want %d", len(items), want)
+	}
+}
+
+func TestHugeOffsetHasNoNextLink(t *testing.T) {
+	_, h := newTestApp(t, nil)
+
+	rec := do(h, http.MethodGet, fmt.Sprintf("/items?offset=%d&limit=10", math.MaxInt), "", "")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Fatalf("status = %d, want 200 and no items: %s", rec.Code, rec.Body)
+	}
+	links := pageLinks(t, rec.Header().Get("Link"))
+	if next, ok := links["next"]; ok {
+		t.Errorf("page past the end links next to %s", next)
+	}
+	if prev := links["prev"]; prev == nil || prev.Query().Get("offset") != strconv.Itoa(math.MaxInt-10) {
+		t.Errorf("prev link = %v, want offset %d", prev, math.MaxInt-10)
+	}
+}
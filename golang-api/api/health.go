@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+type healthStatus int
+
+const (
+	healthy healthStatus = iota
+	degraded
+	unhealthy
+)
+
+func (s healthStatus) String() string {
+	switch s {
+	case degraded:
+		return "degraded"
+	case unhealthy:
+		return "unhealthy"
+	default:
+		return "healthy"
+	}
+}
+
+func (s healthStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type healthResult struct {
+	Status healthStatus `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+}
+
+type healthCheck struct {
+	name  string
+	check func() healthResult
+}
+
+// healthRegistry holds the checks behind /health/ready. Checks are registered
+// while the app is built and only run afterwards, so it needs no lock.
+type healthRegistry struct {
+	checks []healthCheck
+}
+
+func (h *healthRegistry) register(name string, check func() healthResult) {
+	h.checks = append(h.checks, healthCheck{name: name, check: check})
+}
+
+// run executes every check and reports the worst status among them.
+func (h *healthRegistry) run() (healthStatus, map[string]healthResult) {
+	overall := healthy
+	results := make(map[string]healthResult, len(h.checks))
+	for _, c := range h.checks {
+		result := c.check()
+		results[c.name] = result
+		overall = max(overall, result.Status)
+	}
+	return overall, results
+}
+
+// cachedHealth runs the registry's checks at most once per ttl, so aggressive
+// probing doesn't run expensive checks every time. Nothing is cached before the
+// first probe, so that one always sees fresh results.
+type cachedHealth struct {
+	healthRegistry
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	at      time.Time
+	status  healthStatus
+	results map[string]healthResult
+}
+
+// run returns the cached results while they are younger than ttl and runs the
+// checks again otherwise. Concurrent probes wait for one run rather than each
+// starting their own.
+func (c *cachedHealth) run() (healthStatus, map[string]healthResult) {
+	if c.ttl <= 0 {
+		return c.healthRegistry.run()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now := c.now(); c.results == nil || now.Sub(c.at) >= c.ttl {
+		c.status, c.results = c.healthRegistry.run()
+		c.at = now
+	}
+	return c.status, c.results
+}
+
+// itemCountCheck fails once the store holds more than limit items, so load
+// balancers stop routing to an instance that is filling its memory.
+func itemCountCheck(store ItemStore, limit int) func() healthResult {
+	return func() healthResult {
+		if n := store.Stats().Total; n > limit {
+			return healthResult{Status: unhealthy, Detail: fmt.Sprintf("%d items exceeds the limit of %d", n, limit)}
+		}
+		return healthResult{Status: healthy}
+	}
+}
+
+// heapCheck fails once the live heap grows beyond limitMB megabytes.
+func heapCheck(limitMB int) func() healthResult {
+	return func() healthResult {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+
+		heapMB := m.HeapAlloc >> 20
+		if heapMB > uint64(limitMB) {
+			return healthResult{Status: unhealthy, Detail: fmt.Sprintf("heap of %d MB exceeds the limit of %d MB", heapMB, limitMB)}
+		}
+		return healthResult{Status: healthy}
+	}
+}
+
+// listenerState records whether each server is accepting connections, so
+// /health/ready and the gRPC health service agree on it. A listener reports
+// unhealthy until it has bound its port.
+type listenerState struct {
+	mu     sync.Mutex
+	states map[string]error
+}
+
+// set records that the named listener is serving, or why it isn't.
+func (l *listenerState) set(name string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.states == nil {
+		l.states = make(map[string]error)
+	}
+	l.states[name] = err
+}
+
+func (l *listenerState) check(name string) func() healthResult {
+	return func() healthResult {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		err, ok := l.states[name]
+		switch {
+		case !ok:
+			return healthResult{Status: unhealthy, Detail: "not listening yet"}
+		case err != nil:
+			return healthResult{Status: unhealthy, Detail: err.Error()}
+		}
+		return healthResult{Status: healthy}
+	}
+}
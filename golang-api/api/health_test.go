@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadyFailsOverItemLimit(t *testing.T) {
+	app, h := newTestApp(t, map[string]string{"READY_MAX_ITEMS": "4"})
+	app.listeners.set("http", nil)
+	app.listeners.set("grpc", nil)
+
+	// At the limit is fine; only going over it fails.
+	do(h, http.MethodPost, "/items", "application/json", `{"name":"fourth"}`)
+	rec := do(h, http.MethodGet, "/health/ready", "", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"items":{"status":"healthy"`) {
+		t.Fatalf("ready at the limit = %d: %s", rec.Code, rec.Body)
+	}
+
+	do(h, http.MethodPost, "/items", "application/json", `{"name":"fifth"}`)
+	rec = do(h, http.MethodGet, "/health/ready", "", "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("ready over the limit = %d, want 503: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `"items":{"status":"unhealthy","detail":"5 items exceeds the limit of 4"`) {
+		t.Errorf("ready did not name the item check: %s", rec.Body)
+	}
+
+	// Liveness isn't about load, so it still passes.
+	if rec := do(h, http.MethodGet, "/health", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("/health over the item limit = %d, want 200", rec.Code)
+	}
+}
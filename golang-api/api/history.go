@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errSnapshotExists = errors.New("a snapshot with that label already exists")
+
+// labeledSnapshot is a copy of every item as it stood at TakenAt.
+type labeledSnapshot struct {
+	Label   string    `json:"label"`
+	TakenAt Timestamp `json:"takenAt"`
+	Count   int       `json:"count"`
+	items   map[int]Item
+}
+
+// snapshotHistory keeps the most recent labeled snapshots, oldest first. Once
+// it holds retain of them, each new snapshot drops the oldest.
+type snapshotHistory struct {
+	mu        sync.Mutex
+	retain    int
+	snapshots []*labeledSnapshot
+}
+
+// captureSnapshot copies the store's items under label. An empty label names
+// the snapshot after the second it was taken, in RFC 3339.
+func captureSnapshot(store ItemStore, label string, now time.Time) *labeledSnapshot {
+	if label == "" {
+		label = now.UTC().Format(time.RFC3339)
+	}
+
+	items, _ := store.Snapshot()
+	snap := &labeledSnapshot{Label: label, TakenAt: Timestamp(now), Count: len(items), items: make(map[int]Item, len(items))}
+	for _, item := range items {
+		snap.items[item.ID] = item
+	}
+	return snap
+}
+
+// add retains snap, failing if its label is already taken.
+func (h *snapshotHistory) add(snap *labeledSnapshot) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if slices.ContainsFunc(h.snapshots, func(s *labeledSnapshot) bool { return s.Label == snap.Label }) {
+		return errSnapshotExists
+	}
+	if len(h.snapshots) == h.retain {
+		h.snapshots = slices.Delete(h.snapshots, 0, 1)
+	}
+	h.snapshots = append(h.snapshots, snap)
+	return nil
+}
+
+func (h *snapshotHistory) find(label string) (*labeledSnapshot, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range h.snapshots {
+		if s.Label == label {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+func (h *snapshotHistory) list() []*labeledSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.snapshots)
+}
+
+// takeSnapshots adds a timestamp-labeled snapshot every interval, for as long
+// as the process runs.
+func (a *App) takeSnapshots(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := a.history.add(captureSnapshot(a.store, "", a.now())); err != nil {
+			a.logger.Printf("Skipping snapshot: %v", err)
+		}
+	}
+}
+
+// itemChange is an item that differs between two snapshots.
+type itemChange struct {
+	ID     int          `json:"id"`
+	Before ItemResponse `json:"before"`
+	After  ItemResponse `json:"after"`
+}
+
+// diffSnapshots compares two snapshots. An item counts as changed when its
+// updatedAt moved, which the store only does when a write touched it.
+func diffSnapshots(from, to *labeledSnapshot) (added, removed []ItemResponse, changed []itemChange) {
+	added, removed, changed = []ItemResponse{}, []ItemResponse{}, []itemChange{}
+	for _, id := range sortedIDs(to.items) {
+		after := to.items[id]
+		before, ok := from.items[id]
+		switch {
+		case !ok:
+			added = append(added, newItemResponse(&after))
+		case !before.UpdatedAt.Equal(after.UpdatedAt):
+			changed = append(changed, itemChange{ID: id, Before: newItemResponse(&before), After: newItemResponse(&after)})
+		}
+	}
+	for _, id := range sortedIDs(from.items) {
+		if _, ok := to.items[id]; !ok {
+			before := from.items[id]
+			removed = append(removed, newItemResponse(&before))
+		}
+	}
+	return added, removed, changed
+}
+
+func sortedIDs(items map[int]Item) []int {
+	ids := make([]int, 0, len(items))
+	for id := range items {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func (a *App) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.history.list())
+}
+
+func (a *App) createSnapshot(w http.ResponseWriter, r *http.Request) {
+	label := strings.TrimSpace(r.URL.Query().Get("label"))
+	snap := captureSnapshot(a.storeFor(r.Context()), label, a.now())
+	if err := a.history.add(snap); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusCreated, snap)
+}
+
+// diffItems compares the snapshot labeled from against the one labeled to, or
+// against the current items when to is omitted.
+func (a *App) diffItems(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("from") == "" {
+		http.Error(w, "from is required", http.StatusBadRequest)
+		return
+	}
+	from, ok := a.history.find(query.Get("from"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("No snapshot labeled %q", query.Get("from")), http.StatusNotFound)
+		return
+	}
+
+	var to *labeledSnapshot
+	if label := query.Get("to"); label != "" {
+		if to, ok = a.history.find(label); !ok {
+			http.Error(w, fmt.Sprintf("No snapshot labeled %q", label), http.StatusNotFound)
+			return
+		}
+	} else {
+		to = captureSnapshot(a.storeFor(r.Context()), "now", a.now())
+	}
+
+	added, removed, changed := diffSnapshots(from, to)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"from":    from.Label,
+		"to":      to.Label,
+		"added":   added,
+		"removed": removed,
+		"changed": changed,
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// icalTime is the UTC DATE-TIME form RFC 5545 uses.
+const icalTime = "20060102T150405Z"
+
+// icalEscaper escapes TEXT property values (RFC 5545 section 3.3.11).
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// itemsCalendar serves the items that have a due date as an iCalendar feed of
+// VTODOs, so calendar apps can subscribe to it.
+func (a *App) itemsCalendar(w http.ResponseWriter, r *http.Request) {
+	items, version := a.storeFor(r.Context()).Snapshot()
+
+	// Calendar clients poll subscriptions, so let them skip unchanged feeds.
+	lastModified := version.Modified.UTC().Truncate(time.Second)
+	if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(ims) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+
+	stamp := a.now()
+
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		writeICalLine(&b, fmt.Sprintf(format, args...))
+	}
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//aspire-13-samples//golang-api//EN")
+	line("X-WR-CALNAME:Items")
+	for _, item := range items {
+		if item.DueDate == nil {
+			continue
+		}
+		line("BEGIN:VTODO")
+		line("UID:item-%d@%s", item.ID, r.Host)
+		line("DTSTAMP:%s", stamp.UTC().Format(icalTime))
+		line("CREATED:%s", item.CreatedAt.UTC().Format(icalTime))
+		line("LAST-MODIFIED:%s", item.UpdatedAt.UTC().Format(icalTime))
+		line("SUMMARY:%s", icalEscaper.Replace(item.Name))
+		line("DUE:%s", item.DueDate.UTC().Format(icalTime))
+		if len(item.Tags) > 0 {
+			tags := make([]string, len(item.Tags))
+			for i, tag := range item.Tags {
+				tags[i] = icalEscaper.Replace(tag)
+			}
+			line("CATEGORIES:%s", strings.Join(tags, ","))
+		}
+		if item.Completed {
+			line("STATUS:COMPLETED")
+			if item.CompletedAt != nil {
+				line("COMPLETED:%s", item.CompletedAt.UTC().Format(icalTime))
+			}
+		} else {
+			line("STATUS:NEEDS-ACTION")
+		}
+		line("END:VTODO")
+	}
+	line("END:VCALENDAR")
+
+	writeBody(w, http.StatusOK, "text/calendar; charset=utf-8", []byte(b.String()))
+}
+
+// writeICalLine ends a content line with CRLF, folding it so no physical line
+// exceeds 75 octets. Folds never split a UTF-8 sequence.
+func writeICalLine(b *strings.Builder, s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		// Continuation lines start with a space, which counts.
+		limit = 74
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+}
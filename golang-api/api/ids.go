@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// IDGenerator picks the ID of each new item. Next is given the lowest ID above
+// every one used so far and must return an ID no lower than it, so generated
+// IDs only grow and none is handed out twice; now is the store's clock.
+type IDGenerator interface {
+	Next(min int, now time.Time) int
+}
+
+// SequentialIDs numbers items 1, 2, 3 and so on.
+type SequentialIDs struct{}
+
+func (SequentialIDs) Next(min int, _ time.Time) int {
+	return min
+}
+
+// timeIDEpoch is where TimeIDs count seconds from.
+var timeIDEpoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// timeIDSequenceBits leaves room for about a million IDs a second below the
+// seconds, while keeping IDs under 2^53 for centuries, so JavaScript clients
+// read them exactly.
+const timeIDSequenceBits = 20
+
+// TimeIDs embeds the creation time in IDs: the seconds since 2020 above a
+// per-second sequence. They sort by creation, like ULIDs, but stay integers.
+// A second that runs out of sequence numbers borrows from the next.
+type TimeIDs struct{}
+
+func (TimeIDs) Next(min int, now time.Time) int {
+	seconds := int64(now.Sub(timeIDEpoch) / time.Second)
+	if seconds <= 0 || seconds > math.MaxInt>>timeIDSequenceBits {
+		return min
+	}
+	return max(min, int(seconds)<<timeIDSequenceBits)
+}
+
+// WithIDGenerator sets how new items are numbered; the default is
+// SequentialIDs. IDs given to CreateWithID still move later ones past them.
+func WithIDGenerator(g IDGenerator) StoreOption {
+	return func(s *Store) {
+		s.ids = g
+	}
+}
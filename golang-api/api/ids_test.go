@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeIDsOnlyGrow(t *testing.T) {
+	now := timeIDEpoch.Add(1000 * time.Second)
+	s := NewStore(WithIDGenerator(TimeIDs{}), WithClock(func() time.Time { return now }))
+
+	first, err := s.Create(ItemInput{Name: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 1000 << timeIDSequenceBits; first.ID != want {
+		t.Fatalf("first ID = %d, want %d", first.ID, want)
+	}
+	second, _ := s.Create(ItemInput{Name: "second"})
+	if second.ID != first.ID+1 {
+		t.Errorf("second ID in the same second = %d, want %d", second.ID, first.ID+1)
+	}
+
+	// A clock that steps back never takes IDs with it.
+	now = now.Add(-time.Hour)
+	third, _ := s.Create(ItemInput{Name: "third"})
+	if third.ID != second.ID+1 {
+		t.Errorf("ID after the clock stepped back = %d, want %d", third.ID, second.ID+1)
+	}
+
+	now = timeIDEpoch.Add(2000 * time.Second)
+	fourth, _ := s.Create(ItemInput{Name: "fourth"})
+	if want := 2000 << timeIDSequenceBits; fourth.ID != want {
+		t.Errorf("ID in a later second = %d, want %d", fourth.ID, want)
+	}
+}
+
+func TestTimeIDsBorrowFromTheNextSecond(t *testing.T) {
+	now := timeIDEpoch.Add(5 * time.Second)
+	var g TimeIDs
+
+	// The last sequence number of second 5 is the lowest unused ID, so the
+	// next one falls in second 6 even though the clock still reads 5.
+	last := 6<<timeIDSequenceBits - 1
+	if got := g.Next(last, now); got != last {
+		t.Fatalf("Next(last of second 5) = %d, want %d", got, last)
+	}
+	if got := g.Next(last+1, now); got != 6<<timeIDSequenceBits {
+		t.Errorf("Next past the last of second 5 = %d, want the first of second 6, %d", got, 6<<timeIDSequenceBits)
+	}
+	// Once the clock reaches the borrowed second, IDs carry on from there.
+	if got := g.Next(6<<timeIDSequenceBits+1, now.Add(time.Second)); got != 6<<timeIDSequenceBits+1 {
+		t.Errorf("Next in second 6 = %d, want %d", got, 6<<timeIDSequenceBits+1)
+	}
+}
+
+func TestTimeIDsFallBackToSequential(t *testing.T) {
+	var g TimeIDs
+	tests := []struct {
+		name string
+		now  time.Time
+	}{
+		{"at the epoch", timeIDEpoch},
+		{"before the epoch", timeIDEpoch.Add(-time.Hour)},
+		{"zero time", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := g.Next(42, tt.now); got != 42 {
+				t.Errorf("Next(42) = %d, want 42", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// importAttempts is how many times a fetch that fails with a network error
+	// or a 5xx is tried, waiting importBackoff after the first failure and
+	// twice as long after each one since.
+	importAttempts = 3
+	importBackoff  = 100 * time.Millisecond
+)
+
+var (
+	// errPrivateTarget is returned when an import would connect to an
+	// address that isn't on the public internet.
+	errPrivateTarget = errors.New("url must not point at a private, loopback or link-local address")
+	errFetchTimeout  = errors.New("url took too long to respond")
+)
+
+// urlImporter fetches the JSON arrays POST /items/import-url imports. Unless
+// allowPrivate is set it only speaks https, and it refuses to connect to
+// non-public addresses, checked on the resolved address of every connection so
+// neither DNS nor redirects can get around it. Requests go through breaker, one
+// circuit per host.
+type urlImporter struct {
+	client       *http.Client
+	breaker      *circuitBreaker
+	maxBytes     int64
+	allowPrivate bool
+}
+
+func newURLImporter(timeout time.Duration, maxBytes int64, allowPrivate bool, breaker *circuitBreaker) *urlImporter {
+	im := &urlImporter{breaker: breaker, maxBytes: maxBytes, allowPrivate: allowPrivate}
+	dialer := &net.Dialer{Timeout: timeout, Control: im.checkAddress}
+	im.client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// No proxy, which would make the address check meaningless.
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return im.checkURL(req.URL)
+		},
+	}
+	return im
+}
+
+// checkURL fails for URLs the importer won't fetch.
+func (im *urlImporter) checkURL(u *url.URL) error {
+	if u.Host == "" || (u.Scheme != "https" && (!im.allowPrivate || u.Scheme != "http")) {
+		return errors.New("url must be an absolute https URL")
+	}
+	return nil
+}
+
+// checkAddress is the dialer's Control hook, run with the resolved address.
+func (im *urlImporter) checkAddress(_, address string, _ syscall.RawConn) error {
+	if im.allowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	addr = addr.Unmap()
+	if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsUnspecified() || addr.IsMulticast() {
+		return errPrivateTarget
+	}
+	return nil
+}
+
+// do sends req unless the circuit for its host is open. Network errors and 5xx
+// responses count against the host; refused addresses and requests the caller
+// gave up on don't.
+func (im *urlImporter) do(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if err := im.breaker.allow(host); err != nil {
+		return nil, err
+	}
+	resp, err := im.client.Do(req)
+	if err != nil {
+		im.breaker.done(host, !errors.Is(err, errPrivateTarget) && req.Context().Err() == nil)
+		return nil, err
+	}
+	im.breaker.done(host, resp.StatusCode >= 500)
+	return resp, nil
+}
+
+// transientError marks a fetch failure that is worth retrying.
+type transientError struct{ error }
+
+func (e transientError) Unwrap() error { return e.error }
+
+// fetch downloads a JSON array from u, retrying with backoff on network errors
+// and 5xx responses. It stops early once the host's circuit opens. Its error
+// messages are safe to show the client.
+func (im *urlImporter) fetch(ctx context.Context, u *url.URL) ([]json.RawMessage, error) {
+	backoff := importBackoff
+	for attempt := 1; ; attempt++ {
+		entries, err := im.fetchOnce(ctx, u)
+		var transient transientError
+		if err == nil || attempt == importAttempts || !errors.As(err, &transient) {
+			return entries, err
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+func (im *urlImporter) fetchOnce(ctx context.Context, u *url.URL) ([]json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := im.do(req)
+	if err != nil {
+		return nil, fetchError("fetching url", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return nil, transientError{fmt.Errorf("url returned %s", resp.Status)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("url returned %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return nil, fmt.Errorf("url returned %q, not JSON", mediaType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, im.maxBytes+1))
+	if err != nil {
+		return nil, fetchError("reading url", err)
+	}
+	if int64(len(body)) > im.maxBytes {
+		return nil, fmt.Errorf("url returned more than %d bytes", im.maxBytes)
+	}
+	if err := checkJSONLimits(body, maxJSONDepth, maxJSONElements); err != nil {
+		return nil, err
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, errors.New("url did not return a JSON array")
+	}
+	return entries, nil
+}
+
+// fetchError picks out the refusals and timeouts the handler maps to their
+// own statuses; any other error is transient.
+func fetchError(doing string, err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errPrivateTarget):
+		return errPrivateTarget
+	case errors.Is(err, errCircuitOpen):
+		return errCircuitOpen
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return errFetchTimeout
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return transientError{fmt.Errorf("%s: %w", doing, err)}
+}
+
+// importItemsFromURL creates items from the JSON array at a remote URL, each
+// entry on its own as in a partial bulk create, and summarizes the rows it
+// imported and skipped.
+func (a *App) importItemsFromURL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL string `json:"url"`
+	}
+	if !decodeValidated(w, r, a.schemas["item-import-url"], &req) {
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err == nil {
+		err = a.importer.checkURL(u)
+	}
+	if err != nil {
+		http.Error(w, "url must be an absolute https URL", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := a.importer.fetch(r.Context(), u)
+	switch {
+	case errors.Is(err, errPrivateTarget):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, errFetchTimeout):
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		return
+	case errors.Is(err, errCircuitOpen):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(a.importer.breaker.cooldown.Seconds()))))
+		http.Error(w, "url's host: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	results, imported := a.createEntries(r, entries)
+	skipped := make([]bulkResult, 0, len(results)-imported)
+	for _, result := range results {
+		if result.Status != http.StatusCreated {
+			skipped = append(skipped, result)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"imported":    imported,
+		"skipped":     len(skipped),
+		"skippedRows": skipped,
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestImportRetriesTransientFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		failWith  int
+		failures  int
+		wantCode  int
+		wantCalls int
+	}{
+		{"recovers after a 503", http.StatusServiceUnavailable, 1, http.StatusOK, 2},
+		{"gives up after every attempt", http.StatusInternalServerError, importAttempts, http.StatusBadGateway, importAttempts},
+		{"does not retry a 404", http.StatusNotFound, 1, http.StatusBadGateway, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls <= tt.failures {
+					http.Error(w, "failing", tt.failWith)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`[{"name":"Imported"}]`))
+			}))
+			defer remote.Close()
+
+			_, h := newTestApp(t, map[string]string{"IMPORT_ALLOW_PRIVATE": "true"})
+			rec := do(h, http.MethodPost, "/items/import-url", "application/json", `{"url":"`+remote.URL+`"}`)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("remote called %d times, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var resp struct{ Imported int }
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Imported != 1 {
+				t.Errorf("response = %s, want 1 imported", rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+const contentTypeSchema = "application/schema+json"
+
+var timestampType = reflect.TypeOf(Timestamp{})
+
+// itemSchema describes the JSON of an item response, reflected from
+// ItemResponse so it can't drift from what the handlers send. Fields the
+// store sets or responses compute are marked readOnly.
+func itemSchema() map[string]any {
+	properties := map[string]any{}
+	var required []string
+	addProperties(reflect.TypeOf(ItemResponse{}), properties, &required)
+	return map[string]any{
+		"title":                "Item",
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// addProperties adds the JSON members of struct type t. Members of embedded
+// structs are added first, so the outer fields that shadow them win, as they
+// do in encoding/json. Members that can be left out aren't required.
+func addProperties(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.Anonymous {
+			continue
+		}
+		embedded := f.Type
+		if embedded.Kind() == reflect.Pointer {
+			embedded = embedded.Elem()
+		}
+		addProperties(embedded, properties, required)
+	}
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous || !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		schema := typeSchema(f.Type)
+		if field, ok := fieldsByName[name]; ok && field.readOnly {
+			schema["readOnly"] = true
+		}
+		properties[name] = schema
+
+		*required = slices.DeleteFunc(*required, func(r string) bool { return r == name })
+		if f.Type.Kind() != reflect.Pointer && !strings.Contains(","+opts+",", ",omitempty,") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// typeSchema is the schema of one member's Go type. Timestamps follow
+// TIME_FORMAT, like the responses do.
+func typeSchema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timestampType || t == reflect.TypeOf(time.Time{}):
+		if timeFormat == timeFormatUnix {
+			return map[string]any{"type": "integer"}
+		}
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	}
+	panic(fmt.Sprintf("no JSON Schema for item member type %s", t))
+}
+
+// itemSchemas bundles the item schema with the create and update request
+// schemas the API validates against, under $defs.
+func itemSchemas() (map[string]any, error) {
+	defs := map[string]any{"Item": itemSchema()}
+	for def, file := range map[string]string{
+		"ItemCreate": "schemas/item-create.json",
+		"ItemUpdate": "schemas/item-update.json",
+	} {
+		b, err := schemaFS.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var schema map[string]any
+		if err := json.Unmarshal(b, &schema); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		delete(schema, "$schema")
+		defs[def] = schema
+	}
+	return map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$ref":    "#/$defs/Item",
+		"$defs":   defs,
+	}, nil
+}
+
+func (a *App) itemJSONSchema(w http.ResponseWriter, r *http.Request) {
+	doc, err := itemSchemas()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, http.StatusOK, contentTypeSchema, body)
+}
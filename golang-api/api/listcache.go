@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// X-Cache values reported by list responses served from the snapshot.
+const (
+	cacheHit   = "hit"
+	cacheMiss  = "miss"
+	cacheStale = "stale"
+)
+
+// listSnapshot is a point-in-time copy of every item in ID order. The copies
+// are never modified, so requests can filter and page it without locking.
+type listSnapshot struct {
+	items   []*Item
+	version Version
+	builtAt time.Time
+	// expiresAt is the earliest ExpiresAt among items, zero if none expire.
+	// Past it the snapshot holds an expired item and can't be served.
+	expiresAt time.Time
+}
+
+func (s *listSnapshot) query(filter Filter) []*Item {
+	items := make([]*Item, 0, len(s.items))
+	for _, item := range s.items {
+		if filter.Matches(item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// page mirrors Store.Page over the snapshot.
+func (s *listSnapshot) page(filter Filter, afterID, limit int) ([]*Item, int) {
+	var items []*Item
+	for _, item := range s.items {
+		if item.ID <= afterID || !filter.Matches(item) {
+			continue
+		}
+		if len(items) == limit {
+			return items, items[limit-1].ID
+		}
+		items = append(items, item)
+	}
+	return items, 0
+}
+
+// listCache serves GET /items from a snapshot that is rebuilt in the
+// background, so list reads never wait on a writer holding the store lock.
+// The store's change notifications mark the snapshot stale and start a
+// rebuild; ttl bounds how old a snapshot may get before it counts as stale
+// regardless. Stale snapshots are still served while the rebuild runs.
+type listCache struct {
+	store ItemStore
+	ttl   time.Duration
+	now   func() time.Time
+
+	snapshot   atomic.Pointer[listSnapshot]
+	dirty      atomic.Bool
+	refreshing atomic.Bool
+}
+
+func newListCache(store ItemStore, ttl time.Duration, now func() time.Time) *listCache {
+	c := &listCache{store: store, ttl: ttl, now: now}
+	store.OnChange(c.invalidate)
+	return c
+}
+
+// get returns the snapshot to serve and its X-Cache status. Only the first
+// request, or one arriving after an item in the snapshot expired, builds one
+// inline.
+func (c *listCache) get() (*listSnapshot, string) {
+	snap := c.snapshot.Load()
+	if snap == nil || (!snap.expiresAt.IsZero() && !c.now().Before(snap.expiresAt)) {
+		snap = c.build()
+		c.snapshot.Store(snap)
+		return snap, cacheMiss
+	}
+	if c.dirty.Load() || c.now().Sub(snap.builtAt) > c.ttl {
+		c.refresh()
+		return snap, cacheStale
+	}
+	return snap, cacheHit
+}
+
+// invalidate is called by the store, under its write lock, after a change.
+func (c *listCache) invalidate() {
+	c.dirty.Store(true)
+	c.refresh()
+}
+
+// refresh starts a background rebuild unless one is already running. A change
+// that lands while building marks the cache dirty again and triggers another
+// pass, so the last snapshot built always postdates the last change seen.
+func (c *listCache) refresh() {
+	if !c.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer c.refreshing.Store(false)
+		for {
+			c.dirty.Store(false)
+			c.snapshot.Store(c.build())
+			if !c.dirty.Load() {
+				return
+			}
+		}
+	}()
+}
+
+func (c *listCache) build() *listSnapshot {
+	items, version := c.store.Snapshot()
+
+	snap := &listSnapshot{items: make([]*Item, len(items)), version: version, builtAt: c.now()}
+	for i := range items {
+		snap.items[i] = &items[i]
+		if e := items[i].ExpiresAt; e != nil && (snap.expiresAt.IsZero() || e.Before(snap.expiresAt)) {
+			snap.expiresAt = *e
+		}
+	}
+	return snap
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// BenchmarkListUnderWrites reads a page of GET /items from every goroutine
+// while another updates an item every millisecond, answered straight from the store and
+// from the list cache's snapshot. Snapshot reads never wait for the writer's
+// lock.
+func BenchmarkListUnderWrites(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		env  map[string]string
+	}{
+		{"store", nil},
+		{"snapshot", map[string]string{"LIST_CACHE_TTL": "1s"}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			store := newStoreOf(b, 1000)
+			_, h := newTestAppOn(b, bm.env, store)
+
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				tick := time.NewTicker(time.Millisecond)
+				defer tick.Stop()
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					case <-tick.C:
+					}
+					minutes := i
+					store.Update(i%1000+1, ItemUpdate{EstimateMinutes: &minutes})
+				}
+			}()
+
+			b.ReportAllocs()
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if rec := do(h, http.MethodGet, "/items?limit=50", "", ""); rec.Code != http.StatusOK {
+						b.Errorf("status = %d", rec.Code)
+						return
+					}
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			<-done
+		})
+	}
+}
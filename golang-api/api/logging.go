@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type loggerKey struct{}
+
+// LoggerFrom returns the logger installed for the request, falling back to the
+// default logger outside of one.
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// accessLog writes one line per request in chi's default format.
+var accessLog = &middleware.DefaultLogFormatter{Logger: log.New(os.Stdout, "", log.LstdFlags), NoColor: true}
+
+// logAccess logs each request as chi's middleware.Logger does, except that a
+// request whose handler panics is logged with the 500 the recoverer answers
+// it with rather than with no status at all.
+func logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := accessLog.NewLogEntry(r)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+		defer func() {
+			rvr := recover()
+			entry.Write(finalStatus(ww, rvr), ww.BytesWritten(), ww.Header(), time.Since(start), nil)
+			if rvr != nil {
+				panic(rvr)
+			}
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// finalStatus is the status a response went out with, given what recover
+// returned once its handler finished: a panic becomes the recoverer's 500,
+// and a handler that never wrote a header sent 200.
+func finalStatus(ww middleware.WrapResponseWriter, rvr any) int {
+	switch {
+	case ww.Status() != 0:
+		return ww.Status()
+	case rvr != nil && rvr != http.ErrAbortHandler:
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
+
+// requestLogger installs a logger carrying the request id, client, method and
+// path so every line logged while serving a request can be correlated.
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := slog.Default().With(
+			"request_id", middleware.GetReqID(r.Context()),
+			"client_ip", clientIP(r),
+			"method", r.Method,
+			"path", r.URL.Path,
+		)
+		next.ServeHTTP(w, r.WithContext(withLogger(r.Context(), l)))
+	})
+}
+
+// loggerScoped is implemented by stores that can log on a request's behalf.
+type loggerScoped interface {
+	withLogger(l *slog.Logger) ItemStore
+}
+
+// storeFor returns the store with its logging scoped to the request.
+func (a *App) storeFor(ctx context.Context) ItemStore {
+	if s, ok := a.store.(loggerScoped); ok {
+		return s.withLogger(LoggerFrom(ctx))
+	}
+	return a.store
+}
@@ -1,220 +1,241 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"strconv"
-	"sync"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
+	"api/itemspb"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
-type Item struct {
-	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	Completed bool      `json:"completed"`
-	CreatedAt time.Time `json:"createdAt"`
+// seedItems is the initial data, restored by POST /admin/reset.
+var seedItems = []ItemInput{
+	{Name: "Learn Go"},
+	{Name: "Build APIs"},
+	{Name: "Deploy with Aspire"},
 }
 
-type Store struct {
-	mu    sync.RWMutex
-	items map[int]*Item
-	nextID int
-}
+func main() {
+	selfTest := flag.Bool("selftest", false, "run a create/get/list/update/delete cycle in process and exit")
+	flag.Parse()
 
-func NewStore() *Store {
-	return &Store{
-		items: make(map[int]*Item),
-		nextID: 1,
+	env := &envFile{path: os.Getenv("ENV_FILE")}
+	if env.path != "" {
+		if err := env.apply(); err != nil {
+			log.Fatalf("Reading ENV_FILE: %v", err)
+		}
 	}
-}
-
-func (s *Store) GetAll() []*Item {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
 
-	items := make([]*Item, 0, len(s.items))
-	for _, item := range s.items {
-		items = append(items, item)
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-	return items
-}
-
-func (s *Store) Get(id int) (*Item, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	timeFormat = cfg.TimeFormat
+	maxBodyBytes, maxJSONDepth, maxJSONElements = int64(cfg.MaxBodyBytes), cfg.MaxJSONDepth, cfg.MaxJSONElements
+	slog.SetLogLoggerLevel(cfg.LogLevel)
 
-	item, ok := s.items[id]
-	return item, ok
-}
-
-func (s *Store) Create(name string) *Item {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	storeOpts := []StoreOption{WithTagLimits(cfg.MaxTags, cfg.MaxTagLength), WithSlugLength(cfg.SlugLength)}
+	if cfg.MaxItems > 0 {
+		storeOpts = append(storeOpts, WithCapacity(cfg.MaxItems))
+	}
+	if cfg.UniqueScope != UniqueNone {
+		storeOpts = append(storeOpts, WithUniqueScope(cfg.UniqueScope))
+	}
+	if cfg.IDFormat == "time" {
+		storeOpts = append(storeOpts, WithIDGenerator(TimeIDs{}))
+	}
+	store := NewStore(storeOpts...)
 
-	item := &Item{
-		ID:        s.nextID,
-		Name:      name,
-		Completed: false,
-		CreatedAt: time.Now(),
+	restored := 0
+	if cfg.WALPath != "" {
+		if restored, err = store.OpenWAL(cfg.WALPath, cfg.WALCompactAfter, cfg.WALSync); err != nil {
+			log.Fatalf("Opening WAL_PATH: %v", err)
+		}
+		log.Printf("Logging changes to %s, restored %d items from %d entries", cfg.WALPath, store.Stats().Total, restored)
+	}
+	// A restored store keeps its items, even when that is none.
+	if restored == 0 {
+		if seeded := store.Reset(seedItems); len(seeded) < len(seedItems) {
+			log.Printf("Seeded %d of %d items; MAX_ITEMS left no room for the rest", len(seeded), len(seedItems))
+		}
 	}
-	s.items[s.nextID] = item
-	s.nextID++
-	return item
-}
 
-func (s *Store) Update(id int, name *string, completed *bool) (*Item, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	items := newSlowLogStore(store, cfg.SlowThreshold, slog.Default())
 
-	item, ok := s.items[id]
-	if !ok {
-		return nil, false
+	app, err := NewApp(cfg, items, log.Default(), time.Now)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if name != nil {
-		item.Name = *name
+	handler, err := app.routes()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-	if completed != nil {
-		item.Completed = *completed
-	}
-	return item, true
-}
 
-func (s *Store) Delete(id int) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	if *selfTest || cfg.SelfTest {
+		if !runSelfTest(handler, os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
 
-	_, ok := s.items[id]
-	if ok {
-		delete(s.items, id)
+	srv := &http.Server{
+		Addr:           ":" + cfg.Port,
+		Handler:        handler,
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
 	}
-	return ok
-}
+	log.Printf("Limiting HTTP request headers to %d bytes", cfg.MaxHeaderBytes)
 
-func main() {
-	store := NewStore()
-
-	// Add some initial data
-	store.Create("Learn Go")
-	store.Create("Build APIs")
-	store.Create("Deploy with Aspire")
-
-	r := chi.NewRouter()
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(middleware.RequestID)
-
-	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		json.NewEncoder(w).Encode(map[string]string{
-			"message": "Go API with in-memory storage",
-			"version": "1.0.0",
-		})
-	})
-
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
-	})
-
-	r.Get("/items", func(w http.ResponseWriter, r *http.Request) {
-		items := store.GetAll()
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(items)
-	})
-
-	r.Get("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
-		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	// A listener that fails to bind is reported through readiness rather than
+	// ending the process, as long as the other one is serving.
+	listen := func(name, label, port string) net.Listener {
+		lis, err := net.Listen("tcp", ":"+port)
+		app.listeners.set(name, err)
 		if err != nil {
-			http.Error(w, "Invalid ID", http.StatusBadRequest)
-			return
+			log.Printf("Starting %s server on port %s failed: %v", label, port, err)
+			return nil
 		}
+		log.Printf("Starting %s server on port %s", label, port)
+		return lis
+	}
 
-		item, ok := store.Get(id)
-		if !ok {
-			http.Error(w, "Item not found", http.StatusNotFound)
-			return
-		}
+	httpLis := listen("http", "HTTP", cfg.Port)
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(item)
-	})
+	var grpcServer *grpc.Server
+	var grpcLis net.Listener
+	if cfg.GRPCPort != "" {
+		grpcServer = grpc.NewServer()
+		itemspb.RegisterItemServiceServer(grpcServer, &itemServer{app: app})
+		healthpb.RegisterHealthServer(grpcServer, &healthServer{app: app})
+		grpcLis = listen("grpc", "gRPC", cfg.GRPCPort)
+	}
 
-	r.Post("/items", func(w http.ResponseWriter, r *http.Request) {
-		var req struct {
-			Name string `json:"name"`
-		}
+	if httpLis == nil && grpcLis == nil {
+		log.Fatal("No server could bind its port")
+	}
 
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
+	if httpLis != nil {
+		go func() {
+			if err := srv.Serve(httpLis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				app.listeners.set("http", err)
+				log.Printf("HTTP server failed: %v", err)
+			}
+		}()
+	}
+	if grpcLis != nil {
+		go func() {
+			if err := grpcServer.Serve(grpcLis); err != nil {
+				app.listeners.set("grpc", err)
+				log.Printf("gRPC server failed: %v", err)
+			}
+		}()
+	}
 
-		if req.Name == "" {
-			http.Error(w, "Name is required", http.StatusBadRequest)
-			return
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
-		item := store.Create(req.Name)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(item)
-	})
+	go reloadOnHangup(app, env)
+	go sweepExpired(ctx, items, cfg.ExpirySweepInterval)
+	go app.events.reapIdle(ctx)
+	go app.webhooks.run(ctx)
+	if cfg.SnapshotInterval > 0 {
+		go app.takeSnapshots(cfg.SnapshotInterval)
+	}
+	if app.reminders != nil {
+		log.Printf("Reminding about items due within %s, checking every %s", cfg.DueSoonLead, cfg.DueSoonInterval)
+		go app.reminders.run(ctx, cfg.DueSoonInterval)
+	}
 
-	r.Put("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
-		id, err := strconv.Atoi(chi.URLParam(r, "id"))
-		if err != nil {
-			http.Error(w, "Invalid ID", http.StatusBadRequest)
-			return
-		}
+	<-ctx.Done()
+	stop()
+
+	log.Printf("Shutting down, draining %d in-flight requests (timeout %s)", app.inFlight.Load(), cfg.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if grpcServer != nil {
+		// GracefulStop waits for in-flight RPCs with no deadline of its own, so
+		// fall back to Stop once the shared shutdown timeout expires.
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		defer func() {
+			select {
+			case <-stopped:
+			case <-shutdownCtx.Done():
+				grpcServer.Stop()
+			}
+		}()
+	}
 
-		var req struct {
-			Name      *string `json:"name"`
-			Completed *bool   `json:"completed"`
-		}
+	if app.otel != nil {
+		defer func() {
+			if err := app.otel.shutdown(shutdownCtx); err != nil {
+				log.Printf("Flushing OpenTelemetry metrics: %v", err)
+			}
+		}()
+	}
 
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Drain timed out, abandoning %d in-flight requests", app.inFlight.Load())
+		srv.Close()
+		return
+	}
+	log.Printf("Server stopped")
+}
 
-		item, ok := store.Update(id, req.Name, req.Completed)
-		if !ok {
-			http.Error(w, "Item not found", http.StatusNotFound)
+// sweepExpired purges expired items every interval until ctx is done.
+func sweepExpired(ctx context.Context, store ItemStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case now := <-ticker.C:
+			if n := store.PurgeExpired(now); n > 0 {
+				slog.Debug("Purged expired items", "count", n)
+			}
 		}
+	}
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(item)
-	})
-
-	r.Delete("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
-		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+// reloadOnHangup re-reads ENV_FILE and the configuration on every SIGHUP and
+// applies what can change without a restart. An invalid configuration is
+// ignored.
+func reloadOnHangup(app *App, env *envFile) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		if env.path != "" {
+			if err := env.apply(); err != nil {
+				log.Printf("Ignoring SIGHUP, reading ENV_FILE: %v", err)
+				continue
+			}
+		}
+		cfg, err := loadConfig()
 		if err != nil {
-			http.Error(w, "Invalid ID", http.StatusBadRequest)
-			return
+			log.Printf("Ignoring SIGHUP, invalid configuration: %v", err)
+			continue
 		}
-
-		ok := store.Delete(id)
-		if !ok {
-			http.Error(w, "Item not found", http.StatusNotFound)
-			return
+		// An unset CHAOS_SEED is random on every load; keep the one in use.
+		if os.Getenv("CHAOS_SEED") == "" {
+			cfg.ChaosSeed = app.live.Load().ChaosSeed
 		}
-
-		w.WriteHeader(http.StatusNoContent)
-	})
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
-	log.Printf("Starting server on port %s", port)
-	if err := http.ListenAndServe(":"+port, r); err != nil {
-		log.Fatal(err)
+		app.reload(cfg)
 	}
 }
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// maintenanceRetryAfter is the Retry-After hint, in seconds, sent with writes
+// rejected during maintenance.
+const maintenanceRetryAfter = "60"
+
+// Metrics holds request counters for the /metrics endpoint. Statuses are
+// indexed by HTTP status code.
+type Metrics struct {
+	requests atomic.Int64
+	statuses [600]atomic.Int64
+
+	requestSizes  sizeHistogram
+	responseSizes sizeHistogram
+}
+
+// sizeBuckets are the upper bounds, in bytes, of the payload size histograms.
+var sizeBuckets = [...]int64{100, 1_000, 10_000, 100_000, 1_000_000}
+
+// sizeHistogram counts payloads per size bucket. The last bucket is +Inf.
+type sizeHistogram struct {
+	buckets [len(sizeBuckets) + 1]atomic.Int64
+	sum     atomic.Int64
+	count   atomic.Int64
+}
+
+func (h *sizeHistogram) observe(n int64) {
+	i := 0
+	for i < len(sizeBuckets) && n > sizeBuckets[i] {
+		i++
+	}
+	h.buckets[i].Add(1)
+	h.sum.Add(n)
+	h.count.Add(1)
+}
+
+func (h *sizeHistogram) write(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	fmt.Fprintf(w, "# UNIT %s bytes\n", name)
+	cumulative := int64(0)
+	for i := range h.buckets {
+		cumulative += h.buckets[i].Load()
+		le := "+Inf"
+		if i < len(sizeBuckets) {
+			le = strconv.FormatInt(sizeBuckets[i], 10)
+		}
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, le, cumulative)
+	}
+	fmt.Fprintf(w, "%s_sum %d\n", name, h.sum.Load())
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count.Load())
+}
+
+// countingBody counts the request body bytes a handler reads.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// middleware counts requests by status and records payload sizes: request
+// bodies as far as the handler read them, and responses as sent, after
+// compression. chi's wrapper keeps http.Flusher, so streams still flush.
+func (m *Metrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := &countingBody{ReadCloser: r.Body}
+		r.Body = body
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		// Counted on the way out of a panic too, as the recoverer's 500.
+		defer func() {
+			rvr := recover()
+			m.requestSizes.observe(body.n)
+			m.responseSizes.observe(int64(ww.BytesWritten()))
+
+			status := finalStatus(ww, rvr)
+			m.requests.Add(1)
+			if status > 0 && status < len(m.statuses) {
+				m.statuses[status].Add(1)
+			}
+			if rvr != nil {
+				panic(rvr)
+			}
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// writeOpenMetrics renders the counters plus the supplied gauges in the
+// OpenMetrics text exposition format.
+func (m *Metrics) writeOpenMetrics(w io.Writer, items, inFlight int64, limiter *concurrencyLimiter, events *broadcaster) {
+	fmt.Fprintln(w, "# HELP http_requests Total HTTP requests served.")
+	fmt.Fprintln(w, "# TYPE http_requests counter")
+	fmt.Fprintf(w, "http_requests_total %d\n", m.requests.Load())
+
+	fmt.Fprintln(w, "# HELP http_responses HTTP responses by status code.")
+	fmt.Fprintln(w, "# TYPE http_responses counter")
+	for code := range m.statuses {
+		if n := m.statuses[code].Load(); n > 0 {
+			fmt.Fprintf(w, "http_responses_total{code=\"%d\"} %d\n", code, n)
+		}
+	}
+
+	m.requestSizes.write(w, "http_request_size_bytes", "Request body sizes, as read by the handlers.")
+	m.responseSizes.write(w, "http_response_size_bytes", "Response body sizes, as sent.")
+
+	fmt.Fprintln(w, "# HELP http_requests_in_flight HTTP requests currently being served.")
+	fmt.Fprintln(w, "# TYPE http_requests_in_flight gauge")
+	fmt.Fprintf(w, "http_requests_in_flight %d\n", inFlight)
+
+	if limiter != nil {
+		fmt.Fprintln(w, "# HELP http_concurrency_limit Requests MAX_CONCURRENT allows at once.")
+		fmt.Fprintln(w, "# TYPE http_concurrency_limit gauge")
+		fmt.Fprintf(w, "http_concurrency_limit %d\n", cap(limiter.slots))
+		fmt.Fprintln(w, "# HELP http_concurrency_active Requests holding a concurrency slot.")
+		fmt.Fprintln(w, "# TYPE http_concurrency_active gauge")
+		fmt.Fprintf(w, "http_concurrency_active %d\n", len(limiter.slots))
+		fmt.Fprintln(w, "# HELP http_concurrency_rejected Requests refused with 503 for want of a slot.")
+		fmt.Fprintln(w, "# TYPE http_concurrency_rejected counter")
+		fmt.Fprintf(w, "http_concurrency_rejected_total %d\n", limiter.rejected.Load())
+	}
+
+	fmt.Fprintln(w, "# HELP sse_subscribers Clients connected to /items/events.")
+	fmt.Fprintln(w, "# TYPE sse_subscribers gauge")
+	fmt.Fprintf(w, "sse_subscribers %d\n", events.count())
+	fmt.Fprintln(w, "# HELP sse_subscribers_dropped Subscribers dropped for blocking past SSE_SEND_TIMEOUT.")
+	fmt.Fprintln(w, "# TYPE sse_subscribers_dropped counter")
+	fmt.Fprintf(w, "sse_subscribers_dropped_total %d\n", events.dropped.Load())
+	fmt.Fprintln(w, "# HELP sse_subscribers_reaped Subscribers reaped after SSE_IDLE_TIMEOUT without a write.")
+	fmt.Fprintln(w, "# TYPE sse_subscribers_reaped counter")
+	fmt.Fprintf(w, "sse_subscribers_reaped_total %d\n", events.reaped.Load())
+
+	fmt.Fprintln(w, "# HELP items Items currently in the store.")
+	fmt.Fprintln(w, "# TYPE items gauge")
+	fmt.Fprintf(w, "items %d\n", items)
+
+	fmt.Fprintln(w, "# EOF")
+}
+
+// responseTime adds X-Response-Time-Ms to each response as it starts: the
+// milliseconds from the request arriving to its status line, so streams report
+// their time to first byte.
+func responseTime(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&responseTimeWriter{ResponseWriter: w, start: time.Now()}, r)
+	})
+}
+
+type responseTimeWriter struct {
+	http.ResponseWriter
+	start       time.Time
+	wroteHeader bool
+}
+
+func (t *responseTimeWriter) WriteHeader(status int) {
+	if !t.wroteHeader && status >= http.StatusOK {
+		t.wroteHeader = true
+		ms := float64(time.Since(t.start).Microseconds()) / 1000
+		t.Header().Set("X-Response-Time-Ms", strconv.FormatFloat(ms, 'f', 3, 64))
+	}
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *responseTimeWriter) Write(b []byte) (int, error) {
+	if !t.wroteHeader {
+		t.WriteHeader(http.StatusOK)
+	}
+	return t.ResponseWriter.Write(b)
+}
+
+func (t *responseTimeWriter) Flush() {
+	if !t.wroteHeader {
+		t.WriteHeader(http.StatusOK)
+	}
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection.
+func (t *responseTimeWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// chaos injects random latency and failures so resilience patterns can be
+// demonstrated. Health, ping and metrics routes are spared so the orchestrator
+// doesn't restart the service.
+type chaos struct {
+	maxDelay  time.Duration
+	errorRate float64
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newChaos(maxDelay time.Duration, errorRate float64, seed int64) *chaos {
+	return &chaos{
+		maxDelay:  maxDelay,
+		errorRate: errorRate,
+		rng:       rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (c *chaos) roll() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var delay time.Duration
+	if c.maxDelay > 0 {
+		delay = time.Duration(c.rng.Int63n(int64(c.maxDelay) + 1))
+	}
+	return delay, c.rng.Float64() < c.errorRate
+}
+
+func (c *chaos) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isProbe(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		delay, fail := c.roll()
+		if delay > 0 {
+			LoggerFrom(r.Context()).Info("chaos: delaying request", "delay", delay)
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fail {
+			LoggerFrom(r.Context()).Info("chaos: failing request")
+			http.Error(w, "Chaos: injected failure", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isProbe reports whether r is for a health, ping or metrics route, which
+// orchestrators poll and must keep answering under load.
+func isProbe(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/health") || r.URL.Path == "/ping" || r.URL.Path == "/metrics"
+}
+
+// concurrencyLimiter caps how many requests are handled at once. A request
+// over the cap waits up to wait for a slot to free up, then gets 503. Probes
+// bypass it.
+type concurrencyLimiter struct {
+	slots    chan struct{}
+	wait     time.Duration
+	rejected atomic.Int64
+}
+
+func newConcurrencyLimiter(max int, wait time.Duration) *concurrencyLimiter {
+	return &concurrencyLimiter{slots: make(chan struct{}, max), wait: wait}
+}
+
+func (l *concurrencyLimiter) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.wait <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (l *concurrencyLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isProbe(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !l.acquire(r) {
+			l.rejected.Add(1)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many concurrent requests", http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-l.slots }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimiter hands out a token bucket per client IP. Each bucket holds up
+// to burst tokens and refills at rate tokens per second.
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	enforce bool
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute, burst int, enforce bool) *rateLimiter {
+	return &rateLimiter{
+		rate:      float64(perMinute) / 60,
+		burst:     float64(burst),
+		enforce:   enforce,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// take spends a token for key, reporting whether one was available, how many
+// remain, how long until the bucket is full again and how long until the
+// next token.
+func (l *rateLimiter) take(key string, now time.Time) (allowed bool, remaining int, reset, retry time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		allowed = true
+	}
+
+	reset = time.Duration((l.burst - b.tokens) / l.rate * float64(time.Second))
+	if b.tokens < 1 {
+		retry = time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	return allowed, int(b.tokens), reset, retry
+}
+
+// sweep drops buckets that have refilled completely, since a fresh bucket
+// behaves the same. Callers must hold the lock.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Probes neither spend tokens nor get throttled.
+		if isProbe(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		allowed, remaining, reset, retry := l.take(clientIP(r), time.Now())
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(l.burst)))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+
+		if !allowed && l.enforce {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// swappable is a middleware slot whose implementation can be replaced while
+// serving, for settings reloaded on SIGHUP. An empty slot passes requests
+// straight through.
+type swappable struct {
+	mw atomic.Pointer[func(http.Handler) http.Handler]
+}
+
+func (s *swappable) set(mw func(http.Handler) http.Handler) {
+	if mw == nil {
+		s.mw.Store(nil)
+		return
+	}
+	s.mw.Store(&mw)
+}
+
+func (s *swappable) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mw := s.mw.Load(); mw != nil {
+			(*mw)(next).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trackInFlight counts requests currently being served so shutdown progress
+// can be observed from /health and the logs.
+func (a *App) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.inFlight.Add(1)
+		defer a.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAPIKey rejects requests whose X-API-Key header doesn't match key.
+func requireAPIKey(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provided := r.Header.Get("X-API-Key")
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(key)) != 1 {
+				http.Error(w, "Invalid or missing API key", http.StatusUnauthorized)
+				return
+			}
+			// The API key is the only identity there is, so that is who the
+			// request is logged as.
+			ctx := withLogger(r.Context(), LoggerFrom(r.Context()).With("user", "admin"))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// rejectWritesDuringMaintenance answers item writes with 503 while the
+// maintenance flag is set. Reads and admin routes are always let through, as
+// is /graphql, whose queries are POSTs too; its mutations check the flag.
+func (a *App) rejectWritesDuringMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if a.maintenance.Load() && !strings.HasPrefix(r.URL.Path, "/admin/") && r.URL.Path != "/graphql" && r.URL.Path != "/items/validate" {
+				w.Header().Set("Retry-After", maintenanceRetryAfter)
+				http.Error(w, "Service is in maintenance mode; writes are disabled", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cors answers preflight requests and adds CORS headers for allowed origins.
+// With credentials enabled the specific origin is echoed, never "*", since
+// browsers reject a wildcard on credentialed responses.
+type cors struct {
+	origins     map[string]bool
+	anyOrigin   bool
+	methods     []string
+	headers     []string
+	credentials bool
+}
+
+func newCORS(origins, methods, headers []string, credentials bool) *cors {
+	c := &cors{
+		origins:     make(map[string]bool, len(origins)),
+		methods:     methods,
+		headers:     headers,
+		credentials: credentials,
+	}
+	for _, origin := range origins {
+		if origin == "*" {
+			c.anyOrigin = true
+			continue
+		}
+		c.origins[origin] = true
+	}
+	return c
+}
+
+// corsExposedHeaders are the response headers scripts may read.
+const corsExposedHeaders = "Location, Link, ETag, Preference-Applied, X-Total-Count, X-Next-Cursor, " +
+	"X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset"
+
+func (c *cors) allowed(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cors) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Responses differ by origin, so caches must key on it.
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !c.anyOrigin && !c.origins[origin] {
+			if preflight {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowOrigin := origin
+		if c.anyOrigin && !c.credentials {
+			allowOrigin = "*"
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		if c.credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		if !c.allowed(c.methods, r.Header.Get("Access-Control-Request-Method")) {
+			http.Error(w, "Method not allowed by CORS policy", http.StatusForbidden)
+			return
+		}
+		for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			if h = strings.TrimSpace(h); h != "" && !c.allowed(c.headers, h) {
+				http.Error(w, fmt.Sprintf("Header %s not allowed by CORS policy", h), http.StatusForbidden)
+				return
+			}
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.methods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.headers, ", "))
+		w.Header().Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
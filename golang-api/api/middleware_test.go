@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitExemptsProbes(t *testing.T) {
+	_, h := newTestApp(t, map[string]string{"RATE_LIMIT": "60", "RATE_LIMIT_BURST": "1"})
+
+	if rec := do(h, http.MethodGet, "/items", "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want 200", rec.Code)
+	}
+	if rec := do(h, http.MethodGet, "/items", "", ""); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request status = %d, want 429", rec.Code)
+	}
+	for _, target := range []string{"/health", "/health/ready"} {
+		rec := do(h, http.MethodGet, target, "", "")
+		if rec.Code == http.StatusTooManyRequests {
+			t.Errorf("%s was rate limited", target)
+		}
+		if rec.Header().Get("X-RateLimit-Remaining") != "" {
+			t.Errorf("%s carries rate limit headers", target)
+		}
+	}
+}
+
+func TestCORSExposesResponseHeaders(t *testing.T) {
+	_, h := newTestApp(t, map[string]string{"CORS_ORIGINS": "https://app.example.com"})
+
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	exposed := rec.Header().Get("Access-Control-Expose-Headers")
+	for _, header := range []string{"ETag", "Preference-Applied", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"} {
+		if !strings.Contains(exposed, header) {
+			t.Errorf("Access-Control-Expose-Headers = %q, missing %s", exposed, header)
+		}
+	}
+}
+
+func TestCORSPreflightWithCredentials(t *testing.T) {
+	const origin = "https://app.example.com"
+	for _, origins := range []string{origin, "*"} {
+		t.Run("origins "+origins, func(t *testing.T) {
+			_, h := newTestApp(t, map[string]string{"CORS_ORIGINS": origins, "CORS_CREDENTIALS": "true"})
+
+			req := httptest.NewRequest(http.MethodOptions, "/items", nil)
+			req.Header.Set("Origin", origin)
+			req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+			req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("preflight status = %d, want 204: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != origin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want the origin echoed", got)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, http.MethodPatch) {
+				t.Errorf("Access-Control-Allow-Methods = %q, missing PATCH", got)
+			}
+		})
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	_, h := newTestApp(t, nil)
+
+	tests := []struct{ method, path, body string }{
+		{http.MethodGet, "/items", ""},
+		{http.MethodGet, "/items?limit=2", ""},
+		{http.MethodGet, "/items/1", ""},
+		{http.MethodGet, "/items/999", ""},
+		{http.MethodPost, "/items", `{"name":"x"}`},
+		{http.MethodPatch, "/items/2", `{"completed":true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			plain := do(h, tt.method, tt.path, "application/merge-patch+json", tt.body)
+			path, query, _ := strings.Cut(tt.path, "?")
+			slashed := path + "/"
+			if query != "" {
+				slashed += "?" + query
+			}
+			if tt.method == http.MethodPost {
+				// The first create took the name.
+				tt.body = `{"name":"y"}`
+			}
+			rec := do(h, tt.method, slashed, "application/merge-patch+json", tt.body)
+			if rec.Code != plain.Code {
+				t.Errorf("%s = %d, %s = %d", slashed, rec.Code, tt.path, plain.Code)
+			}
+		})
+	}
+
+	t.Run("events", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		req := httptest.NewRequest(http.MethodGet, "/items/events/", nil).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if ct := rec.Header().Get("Content-Type"); rec.Code != http.StatusOK || !strings.HasPrefix(ct, "text/event-stream") {
+			t.Errorf("/items/events/ = %d %q, want an event stream", rec.Code, ct)
+		}
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		_, h := newTestApp(t, map[string]string{"TRAILING_SLASH": "redirect"})
+		rec := do(h, http.MethodGet, "/items/?limit=2", "", "")
+		// chi redirects to a scheme-relative URL on the request's host.
+		loc, err := url.Parse(rec.Header().Get("Location"))
+		if rec.Code != http.StatusMovedPermanently || err != nil || loc.RequestURI() != "/items?limit=2" {
+			t.Errorf("/items/ with redirect = %d Location %q, want 301 to /items?limit=2", rec.Code, rec.Header().Get("Location"))
+		}
+		if rec := do(h, http.MethodGet, "/items", "", ""); rec.Code != http.StatusOK {
+			t.Errorf("/items with redirect = %d, want 200", rec.Code)
+		}
+	})
+}
+
+// stallingStore holds every Get of item 2 until release is closed.
+type stallingStore struct {
+	ItemStore
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *stallingStore) Get(id int) (*Item, bool) {
+	if id == 2 {
+		s.entered <- struct{}{}
+		<-s.release
+	}
+	return s.ItemStore.Get(id)
+}
+
+// TestConcurrencyCapUnderLoad fires more requests at once than MAX_CONCURRENT
+// allows while the first ones hold their slots. Exactly the cap get through;
+// the rest are refused straight away.
+func TestConcurrencyCapUnderLoad(t *testing.T) {
+	const limit, requests = 3, 12
+	store := &stallingStore{ItemStore: newStoreOf(t, 3), entered: make(chan struct{}, requests), release: make(chan struct{})}
+	_, h := newTestAppOn(t, map[string]string{"MAX_CONCURRENT": strconv.Itoa(limit)}, store)
+
+	codes := make(chan *httptest.ResponseRecorder, requests)
+	for range requests {
+		go func() { codes <- do(h, http.MethodGet, "/items/2", "", "") }()
+	}
+
+	// The refusals come back while the admitted requests are still stalled.
+	for range requests - limit {
+		rec := <-codes
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+			t.Errorf("request over the cap = %d Retry-After %q, want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+	for range limit {
+		<-store.entered
+	}
+
+	// Probes and metrics bypass the cap even now.
+	rec := do(h, http.MethodGet, "/metrics", "", "")
+	for _, metric := range []string{
+		fmt.Sprintf("http_concurrency_limit %d", limit),
+		fmt.Sprintf("http_concurrency_active %d", limit),
+		fmt.Sprintf("http_concurrency_rejected_total %d", requests-limit),
+	} {
+		if !strings.Contains(rec.Body.String(), metric) {
+			t.Errorf("/metrics while saturated lacks %q", metric)
+		}
+	}
+
+	close(store.release)
+	for range limit {
+		if rec := <-codes; rec.Code != http.StatusOK {
+			t.Errorf("admitted request = %d, want 200", rec.Code)
+		}
+	}
+	if rec := do(h, http.MethodGet, "/items/2", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("request after the load = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// readOnlyFields are the JSON members a PATCH may never touch.
+var readOnlyFields = fieldNames(func(f itemField) bool { return f.readOnly })
+
+// rejectReadOnly answers a PATCH that touches a read-only field: 400 for the id,
+// which only ever comes from the URL, and 422 for the rest.
+func rejectReadOnly(w http.ResponseWriter, field string) {
+	if field == "id" {
+		http.Error(w, errIDReadOnly.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, fmt.Sprintf("%s is read-only", field), http.StatusUnprocessableEntity)
+}
+
+func patchTouchesReadOnly(patch jsonpatch.Patch) (string, bool) {
+	for _, op := range patch {
+		paths := []string{}
+		if path, err := op.Path(); err == nil {
+			paths = append(paths, path)
+		}
+		if from, err := op.From(); err == nil && op.Kind() == "move" {
+			paths = append(paths, from)
+		}
+
+		for _, path := range paths {
+			for _, field := range readOnlyFields {
+				if path == "/"+field || strings.HasPrefix(path, "/"+field+"/") {
+					return field, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// applyItemPatch runs apply against the item's JSON and decodes the result,
+// rejecting documents that no longer describe a valid item.
+func applyItemPatch(current Item, apply func(doc []byte) ([]byte, error)) (Item, error) {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return Item{}, err
+	}
+
+	patched, err := apply(doc)
+	if err != nil {
+		return Item{}, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	var next Item
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&next); err != nil {
+		return Item{}, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	if next.ID != current.ID || !next.CreatedAt.Equal(current.CreatedAt) || !next.UpdatedAt.Equal(current.UpdatedAt) {
+		return Item{}, fmt.Errorf("%w: id, createdAt and updatedAt are read-only", ErrInvalidPatch)
+	}
+	// completedAt follows completed, so a patch may leave it stale or drop it
+	// but not set it.
+	if next.CompletedAt != nil && (current.CompletedAt == nil || !next.CompletedAt.Equal(*current.CompletedAt)) {
+		return Item{}, fmt.Errorf("%w: completedAt is read-only", ErrInvalidPatch)
+	}
+	if next.Name == "" {
+		return Item{}, fmt.Errorf("%w: name is required", ErrInvalidPatch)
+	}
+	if next.EstimateMinutes < 0 {
+		return Item{}, fmt.Errorf("%w: estimateMinutes must not be negative", ErrInvalidPatch)
+	}
+	return next, nil
+}
+
+// checkExpected compares fields of the item's JSON form against the values a
+// client last saw, naming every field that has since changed.
+func checkExpected(current Item, expected map[string]any) error {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return err
+	}
+
+	var changed []string
+	for name, want := range expected {
+		got, ok := fields[name]
+		if f, known := fieldsByName[name]; !ok && (!known || f.computed) {
+			return fmt.Errorf("%w: unknown field %q in expected values", ErrInvalidPatch, name)
+		}
+		if !reflect.DeepEqual(got, want) {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) > 0 {
+		sort.Strings(changed)
+		return fmt.Errorf("%w since it was read: %s", ErrFieldConflict, strings.Join(changed, ", "))
+	}
+	return nil
+}
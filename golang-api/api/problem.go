@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const contentTypeProblem = "application/problem+json"
+
+// wantsProblem reports whether the client named application/problem+json in
+// Accept with a non-zero q-value. Wildcards don't count, so clients that never
+// asked keep getting the usual error bodies.
+func wantsProblem(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mediaType != contentTypeProblem {
+			continue
+		}
+		if v, ok := params["q"]; ok {
+			if q, err := strconv.ParseFloat(v, 64); err != nil || q <= 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// problemDetails rewrites error responses as RFC 7807 problem documents for
+// clients that ask for them. Plain-text errors become the detail; JSON errors
+// such as validation failures keep their "error" as the detail and any other
+// members, like "violations", as extensions.
+func problemDetails(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !wantsProblem(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		pw := &problemWriter{ResponseWriter: w}
+		next.ServeHTTP(pw, r)
+		if pw.status != 0 {
+			pw.writeProblem(r)
+		}
+	})
+}
+
+// problemWriter holds back error responses so they can be rewritten. Anything
+// below 400 passes straight through.
+type problemWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	status      int // set once an error response is being captured
+	body        bytes.Buffer
+}
+
+func (p *problemWriter) WriteHeader(status int) {
+	if p.wroteHeader {
+		return
+	}
+	p.wroteHeader = true
+	if status >= http.StatusBadRequest {
+		p.status = status
+		return
+	}
+	p.ResponseWriter.WriteHeader(status)
+}
+
+func (p *problemWriter) Write(b []byte) (int, error) {
+	if !p.wroteHeader {
+		p.WriteHeader(http.StatusOK)
+	}
+	if p.status != 0 {
+		return p.body.Write(b)
+	}
+	return p.ResponseWriter.Write(b)
+}
+
+func (p *problemWriter) Flush() {
+	if p.status != 0 {
+		return
+	}
+	if f, ok := p.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection.
+func (p *problemWriter) Unwrap() http.ResponseWriter {
+	return p.ResponseWriter
+}
+
+// writeProblem sends the captured error as an RFC 7807 problem document.
+func (p *problemWriter) writeProblem(r *http.Request) {
+	doc := map[string]any{}
+	mediaType, _, _ := mime.ParseMediaType(p.Header().Get("Content-Type"))
+	switch mediaType {
+	case "text/plain":
+		if detail := strings.TrimSpace(p.body.String()); detail != "" {
+			doc["detail"] = detail
+		}
+	case "application/json":
+		var members map[string]any
+		if json.Unmarshal(p.body.Bytes(), &members) == nil {
+			for name, value := range members {
+				doc[name] = value
+			}
+			if msg, ok := members["error"].(string); ok {
+				delete(doc, "error")
+				doc["detail"] = msg
+			}
+		}
+	}
+
+	// The standard members win over any extension that shares their name.
+	doc["type"] = "about:blank"
+	doc["title"] = http.StatusText(p.status)
+	doc["status"] = p.status
+	doc["instance"] = r.URL.RequestURI()
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		http.Error(p.ResponseWriter, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeBody(p.ResponseWriter, p.status, contentTypeProblem, body)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// reloadableFields are the Config fields a SIGHUP applies to the running app.
+// The rest are fixed at startup.
+var reloadableFields = []string{
+	"LogLevel",
+	"RateLimit", "RateLimitBurst", "RateLimitMode",
+	"CORSOrigins", "CORSMethods", "CORSHeaders", "CORSCredentials",
+}
+
+func corsMiddleware(cfg Config) func(http.Handler) http.Handler {
+	if len(cfg.CORSOrigins) == 0 {
+		return nil
+	}
+	return newCORS(cfg.CORSOrigins, cfg.CORSMethods, cfg.CORSHeaders, cfg.CORSCredentials).middleware
+}
+
+func (a *App) rateLimitMiddleware(cfg Config) func(http.Handler) http.Handler {
+	if cfg.RateLimit == 0 {
+		return nil
+	}
+	a.logger.Printf("Rate limiting %d requests/minute per client (burst %d, mode %s)", cfg.RateLimit, cfg.RateLimitBurst, cfg.RateLimitMode)
+	return newRateLimiter(cfg.RateLimit, cfg.RateLimitBurst, cfg.RateLimitMode == "enforce").middleware
+}
+
+// reload applies the reloadable settings of next to the running app and logs
+// every other change as needing a restart. Middleware is only rebuilt when its
+// settings changed, so rate limit buckets survive unrelated reloads.
+func (a *App) reload(next Config) {
+	prev := *a.live.Load()
+	pv, nv := reflect.ValueOf(prev), reflect.ValueOf(next)
+
+	var applied, restart []string
+	changed := func(names ...string) bool {
+		for _, name := range names {
+			if !reflect.DeepEqual(pv.FieldByName(name).Interface(), nv.FieldByName(name).Interface()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for i := 0; i < pv.NumField(); i++ {
+		name := pv.Type().Field(i).Name
+		switch {
+		case !changed(name):
+		case slices.Contains(reloadableFields, name):
+			applied = append(applied, name)
+		default:
+			restart = append(restart, name)
+		}
+	}
+
+	if changed("LogLevel") {
+		slog.SetLogLoggerLevel(next.LogLevel)
+	}
+	if changed("RateLimit", "RateLimitBurst", "RateLimitMode") {
+		a.rateLimit.set(a.rateLimitMiddleware(next))
+	}
+	if changed("CORSOrigins", "CORSMethods", "CORSHeaders", "CORSCredentials") {
+		a.cors.set(corsMiddleware(next))
+	}
+
+	// Settings that need a restart keep their running values.
+	live := prev
+	lv := reflect.ValueOf(&live).Elem()
+	for _, name := range applied {
+		lv.FieldByName(name).Set(nv.FieldByName(name))
+	}
+	a.live.Store(&live)
+
+	if len(applied) == 0 && len(restart) == 0 {
+		a.logger.Printf("Reloaded configuration: nothing changed")
+		return
+	}
+	if len(applied) > 0 {
+		a.logger.Printf("Reloaded configuration: applied %s", strings.Join(applied, ", "))
+	}
+	if len(restart) > 0 {
+		a.logger.Printf("Reloaded configuration: restart required to apply %s", strings.Join(restart, ", "))
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// dueNotifier reminds about pending items that fall due within lead, logging
+// each one and posting it to the webhook when one is configured. Every item is
+// notified once per due date; moving the due date makes it eligible again.
+// Posts go through breaker, so a webhook that is down is only tried again once
+// its cooldown has passed.
+type dueNotifier struct {
+	store   ItemStore
+	lead    time.Duration
+	webhook string
+	client  *http.Client
+	breaker *circuitBreaker
+	now     func() time.Time
+	logger  *slog.Logger
+
+	// notified maps item IDs to the due date they were notified about. It is
+	// only touched by the run goroutine.
+	notified map[int]time.Time
+}
+
+func newDueNotifier(store ItemStore, lead time.Duration, webhook string, breaker *circuitBreaker, now func() time.Time) *dueNotifier {
+	return &dueNotifier{
+		store:    store,
+		lead:     lead,
+		webhook:  webhook,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		breaker:  breaker,
+		now:      now,
+		logger:   slog.Default().With("component", "due-notifier"),
+		notified: make(map[int]time.Time),
+	}
+}
+
+// run scans every interval until ctx is done.
+func (n *dueNotifier) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n.scan(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (n *dueNotifier) scan(ctx context.Context) {
+	deadline := n.now().Add(n.lead)
+
+	// A snapshot, since items are read outside the store's lock.
+	items, _ := n.store.Snapshot()
+	seen := make(map[int]bool)
+	for i := range items {
+		item := &items[i]
+		if item.Completed || item.DueDate == nil {
+			continue
+		}
+		seen[item.ID] = true
+		if due, ok := n.notified[item.ID]; ok && due.Equal(*item.DueDate) {
+			continue
+		}
+		if item.DueDate.After(deadline) {
+			continue
+		}
+		if err := n.notify(ctx, item); err != nil {
+			// Left unmarked, so the next scan retries it.
+			n.logger.Warn("Due-soon notification failed", "id", item.ID, "err", err)
+			continue
+		}
+		n.notified[item.ID] = *item.DueDate
+	}
+
+	// Forget items that were completed, deleted or lost their due date, so a
+	// reopened item is reminded about again.
+	for id := range n.notified {
+		if !seen[id] {
+			delete(n.notified, id)
+		}
+	}
+}
+
+func (n *dueNotifier) notify(ctx context.Context, item *Item) error {
+	n.logger.Info("Item due soon", "id", item.ID, "name", item.Name, "due", item.DueDate.Format(time.RFC3339))
+	if n.webhook == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"event": "item.due-soon",
+		"item":  newItemResponse(item),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if err := n.breaker.allow(req.URL.Host); err != nil {
+		return err
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		n.breaker.done(req.URL.Host, ctx.Err() == nil)
+		return err
+	}
+	resp.Body.Close()
+	n.breaker.done(req.URL.Host, resp.StatusCode >= 500)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	timeFormatRFC3339 = "rfc3339"
+	timeFormatUnix    = "unix"
+)
+
+// timeFormat controls how Timestamp values are rendered in responses. It is
+// set once from TIME_FORMAT at startup.
+var timeFormat = timeFormatRFC3339
+
+// Timestamp is a time that serializes according to timeFormat.
+type Timestamp time.Time
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if timeFormat == timeFormatUnix {
+		return strconv.AppendInt(nil, time.Time(t).Unix(), 10), nil
+	}
+	return time.Time(t).MarshalJSON()
+}
+
+func (t Timestamp) MarshalText() ([]byte, error) {
+	if timeFormat == timeFormatUnix {
+		return strconv.AppendInt(nil, time.Time(t).Unix(), 10), nil
+	}
+	return time.Time(t).MarshalText()
+}
+
+// in shows t in loc; the instant it names is unchanged.
+func (t Timestamp) in(loc *time.Location) Timestamp {
+	return Timestamp(time.Time(t).In(loc))
+}
+
+// ItemResponse is the wire representation of an item, adding fields that are
+// computed when the item is serialized rather than stored. Its timestamps
+// shadow the item's so they follow the configured TIME_FORMAT.
+type ItemResponse struct {
+	*Item
+	CreatedAt   Timestamp  `json:"createdAt" xml:"createdAt"`
+	UpdatedAt   Timestamp  `json:"updatedAt" xml:"updatedAt"`
+	CompletedAt *Timestamp `json:"completedAt,omitempty" xml:"completedAt,omitempty"`
+	DueDate     *Timestamp `json:"dueDate,omitempty" xml:"dueDate,omitempty"`
+	ExpiresAt   *Timestamp `json:"expiresAt,omitempty" xml:"expiresAt,omitempty"`
+	Progress    int        `json:"progress" xml:"progress"`
+}
+
+func newItemResponse(item *Item) ItemResponse {
+	response := ItemResponse{
+		Item:      item,
+		CreatedAt: Timestamp(item.CreatedAt),
+		UpdatedAt: Timestamp(item.UpdatedAt),
+		Progress:  item.Progress(),
+	}
+	if item.CompletedAt != nil {
+		completedAt := Timestamp(*item.CompletedAt)
+		response.CompletedAt = &completedAt
+	}
+	if item.DueDate != nil {
+		dueDate := Timestamp(*item.DueDate)
+		response.DueDate = &dueDate
+	}
+	if item.ExpiresAt != nil {
+		expiresAt := Timestamp(*item.ExpiresAt)
+		response.ExpiresAt = &expiresAt
+	}
+	return response
+}
+
+// in returns the response with its timestamps shown in loc, or unchanged when
+// loc is nil.
+func (r ItemResponse) in(loc *time.Location) ItemResponse {
+	if loc == nil {
+		return r
+	}
+	r.CreatedAt = r.CreatedAt.in(loc)
+	r.UpdatedAt = r.UpdatedAt.in(loc)
+	for _, t := range []**Timestamp{&r.CompletedAt, &r.DueDate, &r.ExpiresAt} {
+		if *t != nil {
+			shifted := (*t).in(loc)
+			*t = &shifted
+		}
+	}
+	return r
+}
+
+func newItemResponses(items []*Item) []ItemResponse {
+	responses := make([]ItemResponse, len(items))
+	for i, item := range items {
+		responses[i] = newItemResponse(item)
+	}
+	return responses
+}
+
+// xmlList gives a slice the root element XML needs; JSON encodes it as the
+// bare array.
+type xmlList[T any] struct {
+	XMLName xml.Name
+	Items   []T
+}
+
+func (l xmlList[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.Items)
+}
+
+func newXMLList[T any](root string, items []T) xmlList[T] {
+	return xmlList[T]{XMLName: xml.Name{Local: root}, Items: items}
+}
+
+const (
+	formatJSON = "json"
+	formatXML  = "xml"
+)
+
+// negotiate picks the response format from the Accept header, preferring the
+// highest q-value we support. Like browsers, any header that accepts */* gets
+// the JSON default. It reports false when the client only accepts types we
+// can't produce.
+func negotiate(r *http.Request) (string, bool) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formatJSON, true
+	}
+
+	type candidate struct {
+		format string
+		q      float64
+	}
+	var best *candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		var format string
+		switch mediaType {
+		case "*/*":
+			return formatJSON, true
+		case "application/json", "application/*":
+			format = formatJSON
+		case "application/xml", "text/xml":
+			format = formatXML
+		default:
+			continue
+		}
+		if best == nil || q > best.q {
+			best = &candidate{format: format, q: q}
+		}
+	}
+
+	if best == nil {
+		return "", false
+	}
+	return best.format, true
+}
+
+func marshalAs(format string, v any) ([]byte, error) {
+	if format == formatXML {
+		b, err := xml.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(xml.Header), append(b, '\n')...), nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func contentTypeFor(format string) string {
+	if format == formatXML {
+		return "application/xml"
+	}
+	return "application/json"
+}
+
+// writeBody sends a fully encoded response with its Content-Length set.
+func writeBody(w http.ResponseWriter, status int, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		log.Printf("Writing response: %v", err)
+	}
+}
+
+// notModified reports whether the request's validators show the client already
+// has the representation. If-None-Match decides alone when it is sent, using
+// weak comparison, since it is more precise than If-Modified-Since.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !lastModified.After(ims)
+}
+
+// writeJSONArray streams items as the same JSON array marshalAs would encode,
+// flushing every streamFlushEvery items, so a large page is never held in
+// memory whole. It stops early once the client has gone away. Timestamps are
+// shown in loc when it is set.
+func writeJSONArray(w http.ResponseWriter, r *http.Request, items []*Item, loc *time.Location) {
+	w.Header().Set("Content-Type", contentTypeFor(formatJSON))
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	buf := []byte{'['}
+	for i, item := range items {
+		if exportCancelled(r, i, len(items)) {
+			return
+		}
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		b, err := json.Marshal(newItemResponse(item).in(loc))
+		if err != nil {
+			// Too late for a 500; the truncated array tells the client.
+			log.Printf("Encoding item %d: %v", item.ID, err)
+			return
+		}
+		buf = append(buf, b...)
+		if (i+1)%streamFlushEvery == 0 {
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+			buf = buf[:0]
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	buf = append(buf, ']', '\n')
+	if _, err := w.Write(buf); err != nil {
+		log.Printf("Writing response: %v", err)
+	}
+}
+
+// exportCancelled reports whether a streamed export's request is done, the
+// client gone or the deadline passed, and logs how far the export got.
+func exportCancelled(r *http.Request, written, total int) bool {
+	err := r.Context().Err()
+	if err == nil {
+		return false
+	}
+	LoggerFrom(r.Context()).Info("export cancelled", "written", written, "total", total, "reason", err)
+	return true
+}
+
+// writeJSON encodes v before anything is written, so an encoding failure is
+// logged and reported as a clean 500 instead of a truncated body.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	body, err := marshalAs(formatJSON, v)
+	if err != nil {
+		log.Printf("Encoding %T response: %v", v, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, status, contentTypeFor(formatJSON), body)
+}
+
+func writeNotAcceptable(w http.ResponseWriter) {
+	http.Error(w, "Not Acceptable: supported types are application/json and application/xml", http.StatusNotAcceptable)
+}
+
+// respond writes v as JSON or XML according to the request's Accept header.
+func respond(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Add("Vary", "Accept")
+
+	format, ok := negotiate(r)
+	if !ok {
+		writeNotAcceptable(w)
+		return
+	}
+
+	body, err := marshalAs(format, v)
+	if err != nil {
+		log.Printf("Encoding %T response: %v", v, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, status, contentTypeFor(format), body)
+}
+
+// preferences parses RFC 7240 Prefer headers into lowercased preference names
+// and their values. Parameters after ";" are dropped, and the first occurrence
+// of a preference wins.
+func preferences(r *http.Request) map[string]string {
+	prefs := make(map[string]string)
+	for _, header := range r.Header.Values("Prefer") {
+		for _, part := range strings.Split(header, ",") {
+			pref, _, _ := strings.Cut(part, ";")
+			name, value, _ := strings.Cut(pref, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if _, seen := prefs[name]; name == "" || seen {
+				continue
+			}
+			prefs[name] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return prefs
+}
+
+// preferredReturn is the client's return preference, "minimal" or
+// "representation", or "" when it stated neither.
+func preferredReturn(r *http.Request) string {
+	switch v := strings.ToLower(preferences(r)["return"]); v {
+	case "minimal", "representation":
+		return v
+	}
+	return ""
+}
+
+// writeItem answers a successful write of item, whose URL is location. The
+// item is sent back unless the client asked for Prefer: return=minimal, which
+// gets just the Location: 204, or 201 for creates.
+func writeItem(w http.ResponseWriter, r *http.Request, status int, location string, item *Item) {
+	pref := preferredReturn(r)
+	if pref != "" {
+		w.Header().Set("Preference-Applied", "return="+pref)
+	}
+	if pref == "minimal" || status == http.StatusCreated {
+		w.Header().Set("Location", location)
+	}
+
+	if pref == "minimal" {
+		if status == http.StatusOK {
+			status = http.StatusNoContent
+		}
+		w.WriteHeader(status)
+		return
+	}
+	writeJSON(w, status, newItemResponse(item))
+}
+
+// changedFields describes how an item changed as a JSON merge patch (RFC 7396)
+// of its response form: the id and every member whose value changed, with
+// members that are no longer present set to null.
+func changedFields(before, after *Item) (map[string]json.RawMessage, error) {
+	old, err := responseFields(before)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := responseFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]json.RawMessage{"id": cur["id"]}
+	for name, value := range cur {
+		if !bytes.Equal(old[name], value) {
+			changes[name] = value
+		}
+	}
+	for name := range old {
+		if _, ok := cur[name]; !ok {
+			changes[name] = json.RawMessage("null")
+		}
+	}
+	return changes, nil
+}
+
+// responseFields splits an item's JSON response into its encoded members.
+func responseFields(item *Item) (map[string]json.RawMessage, error) {
+	b, err := json.Marshal(newItemResponse(item))
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(b, &fields)
+	return fields, err
+}
+
+// writeStoreError maps a store error onto the matching HTTP status.
+func writeStoreError(w http.ResponseWriter, err error) {
+	status := storeErrorStatus(err)
+	if status == http.StatusNotFound {
+		http.Error(w, "Item not found", status)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrDuplicateName), errors.Is(err, ErrFieldConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrCapacityReached), errors.Is(err, ErrIDsExhausted):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, ErrItemExists):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, ErrInvalidPatch), errors.Is(err, ErrInvalidTags):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrInvalidBlockers):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with testdata/name, or rewrites the file with
+// -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\ngot  %s\nwant %s", name, got, want)
+	}
+}
+
+func TestItemResponseGolden(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := created.Add(36 * time.Hour)
+
+	tests := []struct {
+		golden string
+		item   Item
+	}{
+		{"minimal-item.json", Item{
+			ID: 1, Name: "Learn Go", Slug: "learn-go", CreatedAt: created, UpdatedAt: created,
+		}},
+		{"full-item.json", Item{
+			ID: 2, Name: "Ship it", Slug: "ship-it", Completed: true, EstimateMinutes: 90,
+			Tags: []string{"release", "work"}, CreatedAt: created, UpdatedAt: later,
+			CompletedAt: &later, DueDate: &later, ExpiresAt: &later, BlockedBy: []int{1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			got, err := marshalAs(formatJSON, newItemResponse(&tt.item))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, tt.golden, got)
+		})
+	}
+}
+
+// itemsOf returns n items as the store would hold them.
+func itemsOf(t testing.TB, n int) []*Item {
+	t.Helper()
+	items, _ := newStoreOf(t, n).Snapshot()
+	ptrs := make([]*Item, len(items))
+	for i := range items {
+		ptrs[i] = &items[i]
+	}
+	return ptrs
+}
+
+func TestStreamedJSONArrayMatchesBuffered(t *testing.T) {
+	items := itemsOf(t, 3*streamFlushEvery+7)
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+
+	streamed := httptest.NewRecorder()
+	writeJSONArray(streamed, req, items, nil)
+
+	body, err := marshalAs(formatJSON, newXMLList("items", newItemResponses(items)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffered := httptest.NewRecorder()
+	writeBody(buffered, http.StatusOK, contentTypeFor(formatJSON), body)
+
+	if !bytes.Equal(streamed.Body.Bytes(), buffered.Body.Bytes()) {
+		t.Errorf("streamed body differs from the buffered one:\n%.200s\n%.200s", streamed.Body, buffered.Body)
+	}
+}
+
+// discardResponse is a ResponseWriter that throws the body away, remembering
+// only the largest write, which is as much of the body as was held at once.
+type discardResponse struct {
+	header  http.Header
+	largest int
+}
+
+func (d *discardResponse) Header() http.Header { return d.header }
+func (d *discardResponse) WriteHeader(int)     {}
+func (d *discardResponse) Write(p []byte) (int, error) {
+	d.largest = max(d.largest, len(p))
+	return len(p), nil
+}
+
+// BenchmarkJSONArray compares streaming a large list page with encoding it
+// whole first. Both allocate about as much in all, but held-B, the most of
+// the body in memory at once, stays flat for the streamed encode however big
+// the page gets.
+func BenchmarkJSONArray(b *testing.B) {
+	items := itemsOf(b, 10000)
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+
+	b.Run("streamed", func(b *testing.B) {
+		w := &discardResponse{header: http.Header{}}
+		b.ReportAllocs()
+		for range b.N {
+			writeJSONArray(w, req, items, nil)
+		}
+		b.ReportMetric(float64(w.largest), "held-B")
+	})
+	b.Run("buffered", func(b *testing.B) {
+		w := &discardResponse{header: http.Header{}}
+		b.ReportAllocs()
+		for range b.N {
+			body, err := marshalAs(formatJSON, newXMLList("items", newItemResponses(items)))
+			if err != nil {
+				b.Fatal(err)
+			}
+			writeBody(w, http.StatusOK, contentTypeFor(formatJSON), body)
+		}
+		b.ReportMetric(float64(w.largest), "held-B")
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+// selfTestStep is one request of the self-test and the status it must get.
+type selfTestStep struct {
+	name   string
+	method string
+	path   func() string
+	body   string
+	want   int
+	check  func(body []byte) error
+}
+
+// selfTestItem holds the response fields the self-test checks.
+type selfTestItem struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// runSelfTest drives a create, get, list, update and delete cycle through the
+// full router in process, printing a line per step to out. It reports whether
+// every step passed.
+func runSelfTest(handler http.Handler, out io.Writer) bool {
+	var location string
+	itemPath := func() string { return location }
+
+	var created selfTestItem
+	steps := []selfTestStep{
+		{
+			name: "create", method: http.MethodPost, path: func() string { return "/items" },
+			body: `{"name":"self-test","tags":["selftest"]}`, want: http.StatusCreated,
+			check: func(body []byte) error {
+				if err := json.Unmarshal(body, &created); err != nil {
+					return err
+				}
+				location = fmt.Sprintf("/items/%d", created.ID)
+				return nil
+			},
+		},
+		{
+			name: "get", method: http.MethodGet, path: itemPath, want: http.StatusOK,
+			check: expectName("self-test"),
+		},
+		{
+			name: "list", method: http.MethodGet, path: func() string { return "/items?tag=selftest" }, want: http.StatusOK,
+			check: func(body []byte) error {
+				var items []selfTestItem
+				if err := json.Unmarshal(body, &items); err != nil {
+					return err
+				}
+				for _, item := range items {
+					if item.ID == created.ID {
+						return nil
+					}
+				}
+				return fmt.Errorf("item %d missing from the list", created.ID)
+			},
+		},
+		{
+			name: "update", method: http.MethodPut, path: itemPath,
+			body: `{"name":"self-test updated","completed":true}`, want: http.StatusOK,
+			check: expectName("self-test updated"),
+		},
+		{name: "delete", method: http.MethodDelete, path: itemPath, want: http.StatusNoContent},
+		{name: "get deleted", method: http.MethodGet, path: itemPath, want: http.StatusNotFound},
+	}
+
+	passed := 0
+	for _, step := range steps {
+		if err := step.run(handler); err != nil {
+			fmt.Fprintf(out, "FAIL %s: %v\n", step.name, err)
+			// Later steps depend on earlier ones.
+			break
+		}
+		fmt.Fprintf(out, "PASS %s\n", step.name)
+		passed++
+	}
+
+	ok := passed == len(steps)
+	result := "passed"
+	if !ok {
+		result = "failed"
+	}
+	fmt.Fprintf(out, "Self-test %s: %d/%d steps\n", result, passed, len(steps))
+	return ok
+}
+
+func (s selfTestStep) run(handler http.Handler) error {
+	req := httptest.NewRequest(s.method, s.path(), strings.NewReader(s.body))
+	if s.body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != s.want {
+		return fmt.Errorf("%s %s returned %d, want %d: %s", s.method, req.URL.Path, rec.Code, s.want, strings.TrimSpace(rec.Body.String()))
+	}
+	if s.check != nil {
+		return s.check(rec.Body.Bytes())
+	}
+	return nil
+}
+
+func expectName(name string) func([]byte) error {
+	return func(body []byte) error {
+		var item selfTestItem
+		if err := json.Unmarshal(body, &item); err != nil {
+			return err
+		}
+		if item.Name != name {
+			return fmt.Errorf("name is %q, want %q", item.Name, name)
+		}
+		return nil
+	}
+}
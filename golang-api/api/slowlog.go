@@ -0,0 +1,172 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+const defaultSlowThreshold = 250 * time.Millisecond
+
+// slowLogStore wraps an ItemStore and logs a warning for every call that takes
+// longer than threshold.
+type slowLogStore struct {
+	next      ItemStore
+	threshold time.Duration
+	logger    *slog.Logger
+}
+
+func newSlowLogStore(next ItemStore, threshold time.Duration, logger *slog.Logger) *slowLogStore {
+	return &slowLogStore{next: next, threshold: threshold, logger: logger}
+}
+
+// withLogger returns a copy of the store that logs to l, so slow calls made
+// while serving a request carry its correlation fields.
+func (s *slowLogStore) withLogger(l *slog.Logger) ItemStore {
+	return &slowLogStore{next: s.next, threshold: s.threshold, logger: l}
+}
+
+// observe is deferred with the call's start time.
+func (s *slowLogStore) observe(method string, start time.Time) {
+	if d := time.Since(start); d > s.threshold {
+		s.logger.Warn("slow store operation", "method", method, "duration", d, "threshold", s.threshold)
+	}
+}
+
+func (s *slowLogStore) Version() Version {
+	defer s.observe("Version", time.Now())
+	return s.next.Version()
+}
+
+func (s *slowLogStore) GetAll() []*Item {
+	defer s.observe("GetAll", time.Now())
+	return s.next.GetAll()
+}
+
+func (s *slowLogStore) Snapshot() ([]Item, Version) {
+	defer s.observe("Snapshot", time.Now())
+	return s.next.Snapshot()
+}
+
+func (s *slowLogStore) Query(filter Filter) []*Item {
+	defer s.observe("Query", time.Now())
+	return s.next.Query(filter)
+}
+
+func (s *slowLogStore) Count(filter Filter) int {
+	defer s.observe("Count", time.Now())
+	return s.next.Count(filter)
+}
+
+func (s *slowLogStore) Page(filter Filter, afterID, limit int) ([]*Item, int) {
+	defer s.observe("Page", time.Now())
+	return s.next.Page(filter, afterID, limit)
+}
+
+func (s *slowLogStore) Get(id int) (*Item, bool) {
+	defer s.observe("Get", time.Now())
+	return s.next.Get(id)
+}
+
+func (s *slowLogStore) Blockers(id int) ([]*Item, error) {
+	defer s.observe("Blockers", time.Now())
+	return s.next.Blockers(id)
+}
+
+func (s *slowLogStore) Random(pendingOnly bool) (*Item, bool) {
+	defer s.observe("Random", time.Now())
+	return s.next.Random(pendingOnly)
+}
+
+func (s *slowLogStore) GetBySlug(slug string) (*Item, bool) {
+	defer s.observe("GetBySlug", time.Now())
+	return s.next.GetBySlug(slug)
+}
+
+func (s *slowLogStore) OldestPending() (*Item, bool) {
+	defer s.observe("OldestPending", time.Now())
+	return s.next.OldestPending()
+}
+
+func (s *slowLogStore) Create(in ItemInput) (*Item, error) {
+	defer s.observe("Create", time.Now())
+	return s.next.Create(in)
+}
+
+func (s *slowLogStore) CreateWithID(id int, in ItemInput) (*Item, error) {
+	defer s.observe("CreateWithID", time.Now())
+	return s.next.CreateWithID(id, in)
+}
+
+func (s *slowLogStore) CreateMany(inputs []ItemInput) ([]*Item, error) {
+	defer s.observe("CreateMany", time.Now())
+	return s.next.CreateMany(inputs)
+}
+
+func (s *slowLogStore) Update(id int, update ItemUpdate) (*Item, bool, error) {
+	defer s.observe("Update", time.Now())
+	return s.next.Update(id, update)
+}
+
+func (s *slowLogStore) Reset(seed []ItemInput) []*Item {
+	defer s.observe("Reset", time.Now())
+	return s.next.Reset(seed)
+}
+
+func (s *slowLogStore) BulkTag(ids []int, add, remove []string) ([]*Item, error) {
+	defer s.observe("BulkTag", time.Now())
+	return s.next.BulkTag(ids, add, remove)
+}
+
+func (s *slowLogStore) BulkUpdate(filter Filter, update ItemUpdate) (int, error) {
+	defer s.observe("BulkUpdate", time.Now())
+	return s.next.BulkUpdate(filter, update)
+}
+
+func (s *slowLogStore) Patch(id int, expected map[string]any, fn func(Item) (Item, error)) (*Item, bool, error) {
+	defer s.observe("Patch", time.Now())
+	return s.next.Patch(id, expected, fn)
+}
+
+func (s *slowLogStore) Stats() Stats {
+	defer s.observe("Stats", time.Now())
+	return s.next.Stats()
+}
+
+func (s *slowLogStore) TagCounts() []TagCount {
+	defer s.observe("TagCounts", time.Now())
+	return s.next.TagCounts()
+}
+
+func (s *slowLogStore) Delete(id int) (*Item, bool) {
+	defer s.observe("Delete", time.Now())
+	return s.next.Delete(id)
+}
+
+func (s *slowLogStore) PurgeExpired(now time.Time) int {
+	defer s.observe("PurgeExpired", time.Now())
+	return s.next.PurgeExpired(now)
+}
+
+func (s *slowLogStore) Activity(window, interval time.Duration) []ActivityBucket {
+	defer s.observe("Activity", time.Now())
+	return s.next.Activity(window, interval)
+}
+
+func (s *slowLogStore) Totals() Totals {
+	defer s.observe("Totals", time.Now())
+	return s.next.Totals()
+}
+
+func (s *slowLogStore) Compact() CompactResult {
+	defer s.observe("Compact", time.Now())
+	return s.next.Compact()
+}
+
+func (s *slowLogStore) WithTx(fn func(tx StoreTx) error) error {
+	defer s.observe("WithTx", time.Now())
+	return s.next.WithTx(fn)
+}
+
+func (s *slowLogStore) OnChange(fn func()) {
+	s.next.OnChange(fn)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// sortFieldNames lists the sortable fields for error messages.
+var sortFieldNames = strings.Join(fieldNames(itemField.sortable), ", ")
+
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// itemSort is the order a list request asked for. With no keys, items are
+// listed by ID.
+type itemSort struct {
+	keys   []sortKey
+	locale language.Tag // collates names; only set when a key is name
+}
+
+// parseSort reads the comma-separated sort and order query parameters, such
+// as sort=completed,createdAt&order=asc,desc. Keys without an order are
+// ascending. Without a sort parameter the keys come from def, in the
+// DEFAULT_SORT form parseSortSpec reads. Names are collated for the request's
+// locale.
+func parseSort(r *http.Request, def string) (itemSort, error) {
+	query := r.URL.Query()
+	var s itemSort
+	var err error
+	if query.Get("sort") == "" {
+		if query.Get("order") != "" {
+			return s, fmt.Errorf("order needs sort")
+		}
+		if s.keys, err = parseSortSpec(def); err != nil {
+			return s, err
+		}
+	} else {
+		fields := strings.Split(query.Get("sort"), ",")
+		var orders []string
+		if v := query.Get("order"); v != "" {
+			orders = strings.Split(v, ",")
+		}
+		if len(orders) > len(fields) {
+			return s, fmt.Errorf("order has more entries than sort has keys")
+		}
+		for i, field := range fields {
+			order := "asc"
+			if i < len(orders) {
+				order = orders[i]
+			}
+			if s.keys, err = appendSortKey(s.keys, field, order); err != nil {
+				return s, err
+			}
+		}
+	}
+
+	if s.collated() {
+		locale, err := collationLocale(r)
+		if err != nil {
+			return s, err
+		}
+		s.locale = locale
+	}
+	return s, nil
+}
+
+// parseSortSpec reads a DEFAULT_SORT value: comma-separated keys, each
+// optionally followed by :asc or :desc, such as completed,createdAt:desc.
+func parseSortSpec(v string) ([]sortKey, error) {
+	var keys []sortKey
+	if strings.TrimSpace(v) == "" {
+		return keys, nil
+	}
+	for _, entry := range strings.Split(v, ",") {
+		field, order, ok := strings.Cut(entry, ":")
+		if !ok {
+			order = "asc"
+		}
+		var err error
+		if keys, err = appendSortKey(keys, field, order); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func appendSortKey(keys []sortKey, field, order string) ([]sortKey, error) {
+	field = strings.TrimSpace(field)
+	if f, ok := fieldsByName[field]; !ok || !f.sortable() {
+		return nil, fmt.Errorf("sort key %q must be one of %s", field, sortFieldNames)
+	}
+	if slices.ContainsFunc(keys, func(k sortKey) bool { return k.field == field }) {
+		return nil, fmt.Errorf("sort key %q is repeated", field)
+	}
+	key := sortKey{field: field}
+	switch strings.TrimSpace(order) {
+	case "asc":
+	case "desc":
+		key.desc = true
+	default:
+		return nil, fmt.Errorf("order %q must be asc or desc", order)
+	}
+	return append(keys, key), nil
+}
+
+// byID reports whether the sort is plain ID order, the only one cursors can
+// page through.
+func (s itemSort) byID() bool {
+	return len(s.keys) == 0 || (len(s.keys) == 1 && s.keys[0] == sortKey{field: "id"})
+}
+
+// collated reports whether a key is compared with a collator.
+func (s itemSort) collated() bool {
+	return slices.ContainsFunc(s.keys, func(k sortKey) bool { return fieldsByName[k.field].collate != nil })
+}
+
+// String identifies the sort in cache keys.
+func (s itemSort) String() string {
+	parts := make([]string, len(s.keys))
+	for i, k := range s.keys {
+		parts[i] = k.field
+		if k.desc {
+			parts[i] += ":desc"
+		}
+	}
+	out := strings.Join(parts, ",")
+	if s.collated() {
+		out += "@" + s.locale.String()
+	}
+	return out
+}
+
+// apply sorts items by each key in turn, breaking remaining ties by ID so
+// pages stay stable.
+func (s itemSort) apply(items []*Item) {
+	if s.byID() {
+		return
+	}
+	sortItems := func(c *collate.Collator) {
+		slices.SortFunc(items, func(a, b *Item) int {
+			for _, k := range s.keys {
+				var n int
+				if f := fieldsByName[k.field]; f.collate != nil {
+					n = c.CompareString(f.collate(a), f.collate(b))
+				} else {
+					n = f.compare(a, b)
+				}
+				if k.desc {
+					n = -n
+				}
+				if n != 0 {
+					return n
+				}
+			}
+			return cmp.Compare(a.ID, b.ID)
+		})
+	}
+	if s.collated() {
+		withCollator(s.locale, sortItems)
+	} else {
+		sortItems(nil)
+	}
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
+// compareOptionalTime treats an unset time as later than any set one.
+func compareOptionalTime(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	return a.Compare(*b)
+}
@@ -0,0 +1,1453 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrNotFound        = errors.New("item not found")
+	ErrInvalidPatch    = errors.New("invalid patch")
+	ErrDuplicateName   = errors.New("an item with this name already exists")
+	ErrCapacityReached = errors.New("store is at capacity")
+	ErrFieldConflict   = errors.New("item has changed")
+	ErrItemExists      = errors.New("an item with this ID already exists")
+	ErrIDsExhausted    = errors.New("no item IDs are left")
+	ErrInvalidTags     = errors.New("invalid tags")
+	ErrInvalidBlockers = errors.New("invalid blockedBy")
+)
+
+const (
+	// maxActivityWindow bounds both how far back GET /items/activity can look
+	// and how long the store keeps activity events.
+	maxActivityWindow = 24 * time.Hour
+	// maxActivityEvents caps the event log so bursts can't grow it unbounded.
+	maxActivityEvents = 10000
+	// maxActivityBuckets caps how finely a window can be sliced.
+	maxActivityBuckets = 288
+)
+
+// Item is a stored item. Its JSON tags define the wire contract: core fields
+// are always present, even when zero, while optional fields that an item may
+// simply not have carry omitempty and are left out when empty rather than
+// sent as null.
+type Item struct {
+	XMLName xml.Name `json:"-" xml:"item"`
+	ID      int      `json:"id" xml:"id"`
+	Name    string   `json:"name" xml:"name"`
+	// Slug is the name in URL form, unique across items. The store sets it.
+	Slug            string    `json:"slug" xml:"slug"`
+	Completed       bool      `json:"completed" xml:"completed"`
+	EstimateMinutes int       `json:"estimateMinutes" xml:"estimateMinutes"`
+	Tags            []string  `json:"tags,omitempty" xml:"tags>tag,omitempty"`
+	CreatedAt       time.Time `json:"createdAt" xml:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt" xml:"updatedAt"`
+	// CompletedAt is when the item was last marked done, nil while pending.
+	CompletedAt *time.Time `json:"completedAt,omitempty" xml:"completedAt,omitempty"`
+	DueDate     *time.Time `json:"dueDate,omitempty" xml:"dueDate,omitempty"`
+	// ExpiresAt is when the item is removed; nil items never expire.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" xml:"expiresAt,omitempty"`
+	// BlockedBy lists, in ID order, the items that must be done before this
+	// one; deleting an item drops it from every list. Blocked is set by the
+	// store while any of them is pending.
+	BlockedBy []int `json:"blockedBy,omitempty" xml:"blockedBy,omitempty"`
+	Blocked   bool  `json:"blocked" xml:"blocked"`
+}
+
+// Progress is the item's completion percentage. Items have no subtasks, so
+// it is all or nothing.
+func (i *Item) Progress() int {
+	if i.Completed {
+		return 100
+	}
+	return 0
+}
+
+// ItemInput carries the fields of a new item.
+type ItemInput struct {
+	Name            string
+	Completed       bool
+	EstimateMinutes int
+	Tags            []string
+	DueDate         *time.Time
+	ExpiresAt       *time.Time
+	BlockedBy       []int
+}
+
+// ItemUpdate carries the fields of an update; nil fields are left unchanged.
+type ItemUpdate struct {
+	Name            *string
+	Completed       *bool
+	EstimateMinutes *int
+	Tags            *[]string
+	DueDate         *time.Time
+	ExpiresAt       *time.Time
+	BlockedBy       *[]int
+}
+
+// SearchField is an item field that Filter.Query can be matched against.
+type SearchField string
+
+// SearchName is what Filter.Query searches without a QueryIn.
+const SearchName SearchField = "name"
+
+// searchFields is the searchable set: the item fields with text to match, in
+// the order they are listed to clients.
+var searchFields = func() []SearchField {
+	var fields []SearchField
+	for _, f := range itemFields {
+		if f.text != nil {
+			fields = append(fields, SearchField(f.name))
+		}
+	}
+	return fields
+}()
+
+// Filter selects items; unset fields match everything and set fields must
+// all match.
+type Filter struct {
+	Completed *bool
+	Tag       string
+	// Query matches items where any of the QueryIn fields contains it,
+	// ignoring case. An empty QueryIn searches the name alone.
+	Query   string
+	QueryIn []SearchField
+	// Ranges bound the times of the item fields they are keyed by. An item
+	// without the time, like a pending item's completedAt, matches no range.
+	Ranges map[string]TimeRange
+}
+
+// TimeRange bounds a time inclusively; a zero bound is open.
+type TimeRange struct {
+	After  time.Time
+	Before time.Time
+}
+
+func (t TimeRange) contains(at *time.Time) bool {
+	if at == nil {
+		return false
+	}
+	return (t.After.IsZero() || !at.Before(t.After)) && (t.Before.IsZero() || !at.After(t.Before))
+}
+
+func (f Filter) Matches(item *Item) bool {
+	if f.Completed != nil && item.Completed != *f.Completed {
+		return false
+	}
+	if f.Tag != "" && !containsTag(item.Tags, f.Tag) {
+		return false
+	}
+	if f.Query != "" && !f.matchesQuery(item) {
+		return false
+	}
+	for name, bounds := range f.Ranges {
+		if !bounds.contains(fieldsByName[name].at(item)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f Filter) matchesQuery(item *Item) bool {
+	query := strings.ToLower(f.Query)
+	fields := f.QueryIn
+	if len(fields) == 0 {
+		fields = []SearchField{SearchName}
+	}
+	for _, field := range fields {
+		for _, text := range fieldsByName[string(field)].text(item) {
+			if strings.Contains(text, query) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsTag(tags []string, tag string) bool {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+type TagCount struct {
+	XMLName xml.Name `json:"-" xml:"tagCount"`
+	Tag     string   `json:"tag" xml:"tag"`
+	Count   int      `json:"count" xml:"count"`
+}
+
+type Stats struct {
+	XMLName                xml.Name `json:"-" xml:"stats"`
+	Total                  int      `json:"total" xml:"total"`
+	Completed              int      `json:"completed" xml:"completed"`
+	Pending                int      `json:"pending" xml:"pending"`
+	PendingEstimateMinutes int      `json:"pendingEstimateMinutes" xml:"pendingEstimateMinutes"`
+	AverageProgress        float64  `json:"averageProgress" xml:"averageProgress"`
+}
+
+type ActivityKind int
+
+const (
+	ActivityCreated ActivityKind = iota
+	ActivityCompleted
+	ActivityDeleted
+)
+
+type activityEvent struct {
+	kind ActivityKind
+	at   time.Time
+}
+
+// Totals counts the items created, completed and deleted since the store was
+// created. Unlike Stats, they only ever go up: deleting an item doesn't take
+// back its creation.
+type Totals struct {
+	Created   int64 `json:"created"`
+	Completed int64 `json:"completed"`
+	Deleted   int64 `json:"deleted"`
+}
+
+// CompactResult reports what Compact did. The WAL counts are lines in the log,
+// both zero when there is none.
+type CompactResult struct {
+	Items            int `json:"items"`
+	WALEntriesBefore int `json:"walEntriesBefore"`
+	WALEntriesAfter  int `json:"walEntriesAfter"`
+}
+
+type ActivityBucket struct {
+	Start     time.Time `json:"start"`
+	Created   int       `json:"created"`
+	Completed int       `json:"completed"`
+	Deleted   int       `json:"deleted"`
+}
+
+// Version identifies a state of the store. Revision counts changes, so it
+// tells apart states whose Modified times are too close to differ; Modified
+// is when the last change happened.
+type Version struct {
+	Revision uint64
+	Modified time.Time
+}
+
+// ItemStore is everything the handlers need from item storage. Store is the
+// in-memory implementation; decorators such as slowLogStore wrap any of them.
+// Items it returns are the caller's own, unaffected by later writes.
+type ItemStore interface {
+	Version() Version
+	GetAll() []*Item
+	Snapshot() ([]Item, Version)
+	Query(filter Filter) []*Item
+	Count(filter Filter) int
+	Page(filter Filter, afterID, limit int) ([]*Item, int)
+	Get(id int) (*Item, bool)
+	GetBySlug(slug string) (*Item, bool)
+	Blockers(id int) ([]*Item, error)
+	Random(pendingOnly bool) (*Item, bool)
+	OldestPending() (*Item, bool)
+	Create(in ItemInput) (*Item, error)
+	CreateWithID(id int, in ItemInput) (*Item, error)
+	CreateMany(inputs []ItemInput) ([]*Item, error)
+	Update(id int, update ItemUpdate) (*Item, bool, error)
+	BulkUpdate(filter Filter, update ItemUpdate) (int, error)
+	BulkTag(ids []int, add, remove []string) ([]*Item, error)
+	Patch(id int, expected map[string]any, fn func(Item) (Item, error)) (*Item, bool, error)
+	Stats() Stats
+	TagCounts() []TagCount
+	Delete(id int) (*Item, bool)
+	PurgeExpired(now time.Time) int
+	Reset(seed []ItemInput) []*Item
+	Activity(window, interval time.Duration) []ActivityBucket
+	Totals() Totals
+	Compact() CompactResult
+	WithTx(fn func(tx StoreTx) error) error
+	OnChange(fn func())
+}
+
+type Store struct {
+	mu           sync.RWMutex
+	items        map[int]*Item
+	slugs        map[string]int // Slug to ID
+	nextID       int            // the lowest ID insert may allocate; only Reset lowers it
+	ids          IDGenerator
+	now          func() time.Time
+	capacity     int
+	uniqueScope  UniqueScope
+	maxTags      int
+	maxTagLength int
+	maxSlugLen   int
+	activity     []activityEvent
+	lastModified time.Time
+	revision     uint64 // changes since the store was created
+	onChange     []func()
+	wal          *wal // nil unless OpenWAL was called
+
+	// nextExpiry is the earliest ExpiresAt in the store as Unix nanoseconds,
+	// or zero when no item expires. It may run early, after the item it came
+	// from changed, which only costs a purge that finds nothing.
+	nextExpiry atomic.Int64
+
+	// totals count activity by kind, and are read without the lock. While a
+	// transaction runs its activity is counted in txTotals instead, and added
+	// only if it commits.
+	totals   [ActivityDeleted + 1]atomic.Int64
+	txTotals *[ActivityDeleted + 1]int64
+}
+
+type StoreOption func(*Store)
+
+// WithClock sets the time source used for timestamps, letting tests freeze time.
+func WithClock(now func() time.Time) StoreOption {
+	return func(s *Store) {
+		s.now = now
+	}
+}
+
+// WithCapacity caps how many items the store holds; zero means unlimited.
+func WithCapacity(n int) StoreOption {
+	return func(s *Store) {
+		s.capacity = n
+	}
+}
+
+// WithTagLimits caps how many tags an item has and how many characters each
+// tag has, counted after normalization; zero means unlimited.
+func WithTagLimits(count, length int) StoreOption {
+	return func(s *Store) {
+		s.maxTags = count
+		s.maxTagLength = length
+	}
+}
+
+// WithSlugLength caps how many characters of the name a slug keeps, before
+// any suffix that tells it apart from another; zero means no cap.
+func WithSlugLength(n int) StoreOption {
+	return func(s *Store) {
+		s.maxSlugLen = n
+	}
+}
+
+// UniqueScope says which other items an item's name must differ from. Names
+// are compared case-insensitively.
+type UniqueScope string
+
+const (
+	UniqueNone   UniqueScope = "none"
+	UniqueGlobal UniqueScope = "global"
+)
+
+// WithUniqueScope makes creates and renames fail when another item in the
+// same scope already has the name.
+func WithUniqueScope(scope UniqueScope) StoreOption {
+	return func(s *Store) {
+		s.uniqueScope = scope
+	}
+}
+
+func NewStore(opts ...StoreOption) *Store {
+	s := &Store{
+		items:  make(map[int]*Item),
+		slugs:  make(map[string]int),
+		nextID: 1,
+		ids:    SequentialIDs{},
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.lastModified = s.now()
+	return s
+}
+
+// Version identifies the current set of items.
+func (s *Store) Version() Version {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	return s.version()
+}
+
+func (s *Store) version() Version {
+	return Version{Revision: s.revision, Modified: s.lastModified}
+}
+
+func (s *Store) GetAll() []*Item {
+	return s.Query(Filter{})
+}
+
+// Query returns the items matching filter in ID order.
+func (s *Store) Query(filter Filter) []*Item {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	items := make([]*Item, 0, len(s.items))
+	for _, item := range s.items {
+		if filter.Matches(item) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ID < items[j].ID
+	})
+	return detachAll(items)
+}
+
+// Count is how many items match filter, without collecting them.
+func (s *Store) Count(filter Filter) int {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, item := range s.items {
+		if filter.Matches(item) {
+			n++
+		}
+	}
+	return n
+}
+
+// Snapshot copies every item, in ID order, together with the version they
+// were copied at, all under one read lock.
+func (s *Store) Snapshot() ([]Item, Version) {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	items := make([]Item, 0, len(s.items))
+	for _, item := range s.items {
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ID < items[j].ID
+	})
+	return items, s.version()
+}
+
+// Page returns up to limit items matching filter with IDs above afterID, in
+// ID order, plus the cursor for the next page, which is zero on the last page.
+// Since IDs only grow, items created between pages never shift the results.
+func (s *Store) Page(filter Filter, afterID, limit int) ([]*Item, int) {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	ids := make([]int, 0, len(s.items))
+	for id, item := range s.items {
+		if id > afterID && filter.Matches(item) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	next := 0
+	if len(ids) > limit {
+		ids = ids[:limit]
+		next = ids[limit-1]
+	}
+
+	items := make([]*Item, len(ids))
+	for i, id := range ids {
+		items[i] = s.items[id]
+	}
+	return detachAll(items), next
+}
+
+func (s *Store) Get(id int) (*Item, bool) {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	item, ok := s.items[id]
+	if !ok {
+		return nil, false
+	}
+	return detach(item), true
+}
+
+// Blockers returns the items that block item id, in ID order.
+func (s *Store) Blockers(id int) ([]*Item, error) {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	item, ok := s.items[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	blockers := make([]*Item, 0, len(item.BlockedBy))
+	for _, blocker := range item.BlockedBy {
+		if b, ok := s.items[blocker]; ok {
+			blockers = append(blockers, detach(b))
+		}
+	}
+	return blockers, nil
+}
+
+// GetBySlug finds an item by its current slug. Slugs follow renames, so an
+// item's old slug stops resolving once it is renamed.
+func (s *Store) GetBySlug(slug string) (*Item, bool) {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.slugs[slug]
+	if !ok {
+		return nil, false
+	}
+	return detach(s.items[id]), true
+}
+
+// detach copies item for a caller outside the lock, so a later write can't
+// change it while it is read. Writes replace an item's tags and times rather
+// than editing them in place, which makes a shallow copy enough. Callers must
+// hold the lock.
+func detach(item *Item) *Item {
+	c := *item
+	return &c
+}
+
+// detachAll is detach for every item, with one allocation for the copies.
+func detachAll(items []*Item) []*Item {
+	copies := make([]Item, len(items))
+	for i, item := range items {
+		copies[i] = *item
+		items[i] = &copies[i]
+	}
+	return items
+}
+
+// Random picks an item uniformly at random, only considering pending items
+// when pendingOnly is set. The global math/rand source is seeded at startup.
+func (s *Store) Random(pendingOnly bool) (*Item, bool) {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	candidates := make([]*Item, 0, len(s.items))
+	for _, item := range s.items {
+		if pendingOnly && item.Completed {
+			continue
+		}
+		candidates = append(candidates, item)
+	}
+	if len(candidates) == 0 {
+		return nil, false
+	}
+	return detach(candidates[rand.Intn(len(candidates))]), true
+}
+
+// OldestPending returns the pending item created first, breaking ties by ID.
+func (s *Store) OldestPending() (*Item, bool) {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	var oldest *Item
+	for _, item := range s.items {
+		if item.Completed {
+			continue
+		}
+		if oldest == nil || item.CreatedAt.Before(oldest.CreatedAt) ||
+			(item.CreatedAt.Equal(oldest.CreatedAt) && item.ID < oldest.ID) {
+			oldest = item
+		}
+	}
+	if oldest == nil {
+		return nil, false
+	}
+	return detach(oldest), true
+}
+
+// namesUnique reports whether the store enforces unique names at all.
+func (s *Store) namesUnique() bool {
+	return s.uniqueScope == UniqueGlobal
+}
+
+// nameTaken reports whether an item other than exceptID is called name within
+// the store's unique scope. Callers must hold the lock.
+func (s *Store) nameTaken(name string, exceptID int) bool {
+	if !s.namesUnique() {
+		return false
+	}
+	for id, item := range s.items {
+		if id != exceptID && strings.EqualFold(item.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) Create(in ItemInput) (*Item, error) {
+	s.lock()
+	defer s.mu.Unlock()
+	return s.create(in)
+}
+
+// create checks capacity and names before inserting. Callers must hold the
+// write lock.
+func (s *Store) create(in ItemInput) (*Item, error) {
+	if s.capacity > 0 && len(s.items) >= s.capacity {
+		return nil, ErrCapacityReached
+	}
+	if s.freeIDs() < 1 {
+		return nil, ErrIDsExhausted
+	}
+	if s.nameTaken(in.Name, 0) {
+		return nil, ErrDuplicateName
+	}
+	if err := s.checkTags(normalizeTags(in.Tags)); err != nil {
+		return nil, err
+	}
+	if err := s.checkBlockers(0, normalizeBlockers(in.BlockedBy)); err != nil {
+		return nil, err
+	}
+	return detach(s.insert(in)), nil
+}
+
+// freeIDs is how many IDs insert can still hand out. The last one,
+// math.MaxInt, is never allocated so nextID can't overflow, though
+// CreateWithID can still use it.
+func (s *Store) freeIDs() int {
+	return math.MaxInt - s.nextID
+}
+
+// CreateWithID creates an item under the given ID, failing with ErrItemExists
+// if that ID is taken. Later creates are numbered after the highest ID used.
+func (s *Store) CreateWithID(id int, in ItemInput) (*Item, error) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[id]; ok {
+		return nil, ErrItemExists
+	}
+	if s.capacity > 0 && len(s.items) >= s.capacity {
+		return nil, ErrCapacityReached
+	}
+	if s.nameTaken(in.Name, 0) {
+		return nil, ErrDuplicateName
+	}
+	if err := s.checkTags(normalizeTags(in.Tags)); err != nil {
+		return nil, err
+	}
+	if err := s.checkBlockers(id, normalizeBlockers(in.BlockedBy)); err != nil {
+		return nil, err
+	}
+	return detach(s.insertAt(id, in)), nil
+}
+
+// Reset empties the store, restarts IDs at 1 and forgets past activity, then
+// creates each of seed that capacity and name rules allow. It returns the items
+// it created, so a caller can tell when some of seed were skipped.
+func (s *Store) Reset(seed []ItemInput) []*Item {
+	s.lock()
+	defer s.mu.Unlock()
+
+	s.items = make(map[int]*Item, len(seed))
+	s.slugs = make(map[string]int, len(seed))
+	s.nextID = 1
+	s.activity = nil
+	s.nextExpiry.Store(0)
+	s.touch(s.now())
+	s.journal(walEntry{Op: walReset})
+
+	items := make([]*Item, 0, len(seed))
+	for _, in := range seed {
+		if item, err := s.create(in); err == nil {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// CreateMany creates all of inputs or, if any of them can't be created, none.
+func (s *Store) CreateMany(inputs []ItemInput) ([]*Item, error) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	if s.capacity > 0 && len(s.items)+len(inputs) > s.capacity {
+		return nil, fmt.Errorf("%w: room for %d more items", ErrCapacityReached, s.capacity-len(s.items))
+	}
+	if len(inputs) > s.freeIDs() {
+		return nil, fmt.Errorf("%w: %d more can be allocated", ErrIDsExhausted, s.freeIDs())
+	}
+	if s.namesUnique() {
+		batch := make(map[string]bool, len(inputs))
+		for i, in := range inputs {
+			name := strings.ToLower(in.Name)
+			if batch[name] || s.nameTaken(in.Name, 0) {
+				return nil, fmt.Errorf("item %d: %w", i, ErrDuplicateName)
+			}
+			batch[name] = true
+		}
+	}
+	for i, in := range inputs {
+		if err := s.checkTags(normalizeTags(in.Tags)); err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+		if err := s.checkBlockers(0, normalizeBlockers(in.BlockedBy)); err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+	}
+
+	items := make([]*Item, len(inputs))
+	for i, in := range inputs {
+		items[i] = detach(s.insert(in))
+	}
+	return items, nil
+}
+
+// insert adds a new item built from in under the next free ID. Callers must
+// hold the write lock and have checked capacity and names.
+func (s *Store) insert(in ItemInput) *Item {
+	return s.insertAt(s.ids.Next(s.nextID, s.now()), in)
+}
+
+// insertAt is insert for an ID the caller has checked is free.
+func (s *Store) insertAt(id int, in ItemInput) *Item {
+	now := s.now()
+	item := &Item{
+		ID:              id,
+		Name:            in.Name,
+		Completed:       in.Completed,
+		EstimateMinutes: in.EstimateMinutes,
+		Tags:            normalizeTags(in.Tags),
+		DueDate:         in.DueDate,
+		ExpiresAt:       in.ExpiresAt,
+		BlockedBy:       normalizeBlockers(in.BlockedBy),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	item.Blocked = s.isBlocked(item)
+	s.items[id] = item
+	s.assignSlug(item)
+	if id >= s.nextID && id < math.MaxInt {
+		s.nextID = id + 1
+	}
+	s.noteExpiry(item.ExpiresAt)
+	s.refreshBlocked(id)
+	s.touch(item.CreatedAt)
+	s.record(ActivityCreated)
+	if in.Completed {
+		completedAt := now
+		item.CompletedAt = &completedAt
+		s.record(ActivityCompleted)
+	}
+	s.journalPut(item)
+	return item
+}
+
+// Update applies every field of update under one hold of the write lock, so
+// concurrent updates to an item never interleave: each sees the other's
+// changes either in full or not at all. Clients that must not overwrite a
+// change they haven't seen should PATCH with X-Expected-Values. It reports
+// whether any field changed; an update that changes nothing leaves the item,
+// its UpdatedAt and the store's version alone.
+func (s *Store) Update(id int, update ItemUpdate) (*Item, bool, error) {
+	s.lock()
+	defer s.mu.Unlock()
+	return s.update(id, update)
+}
+
+// update applies update to an existing item. Callers must hold the write
+// lock.
+func (s *Store) update(id int, update ItemUpdate) (*Item, bool, error) {
+	item, ok := s.items[id]
+	if !ok {
+		return nil, false, ErrNotFound
+	}
+	if update.Name != nil && s.nameTaken(*update.Name, id) {
+		return nil, false, ErrDuplicateName
+	}
+	if update.Tags != nil {
+		if err := s.checkTags(normalizeTags(*update.Tags)); err != nil {
+			return nil, false, err
+		}
+	}
+	if update.BlockedBy != nil {
+		if err := s.checkBlockers(id, normalizeBlockers(*update.BlockedBy)); err != nil {
+			return nil, false, err
+		}
+	}
+
+	changed := s.apply(item, update)
+	if changed {
+		s.touch(item.UpdatedAt)
+		s.journalPut(item)
+	}
+	return detach(item), changed, nil
+}
+
+// BulkUpdate applies update to every item matching filter under a single
+// write lock and returns how many items it touched. Renames aren't allowed
+// in bulk since they would give every match the same name. Nor are blockers,
+// which are checked against cycles one item at a time; setting them fails
+// with ErrInvalidBlockers.
+func (s *Store) BulkUpdate(filter Filter, update ItemUpdate) (int, error) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	update.Name = nil
+	if update.BlockedBy != nil {
+		return 0, fmt.Errorf("%w: blockers can't be set in bulk", ErrInvalidBlockers)
+	}
+	if update.Tags != nil {
+		if err := s.checkTags(normalizeTags(*update.Tags)); err != nil {
+			return 0, err
+		}
+	}
+	count, changed := 0, false
+	for _, item := range s.items {
+		if filter.Matches(item) {
+			if s.apply(item, update) {
+				s.journalPut(item)
+				changed = true
+			}
+			count++
+		}
+	}
+	if changed {
+		s.touch(s.now())
+	}
+	return count, nil
+}
+
+// BulkTag adds and removes tags across the items with the given ids under a
+// single write lock, returning those items in the order asked for. Unknown ids
+// are skipped. A tag that is both added and removed ends up removed. If any
+// item would break the tag limits, no item changes.
+func (s *Store) BulkTag(ids []int, add, remove []string) ([]*Item, error) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	add, remove = normalizeTags(add), normalizeTags(remove)
+	targets := make([]*Item, 0, len(ids))
+	retagged := make([][]string, 0, len(ids))
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		item, ok := s.items[id]
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		tags := normalizeTags(append(slices.Clone(item.Tags), add...))
+		tags = slices.DeleteFunc(tags, func(tag string) bool { return slices.Contains(remove, tag) })
+		if len(tags) == 0 {
+			tags = nil
+		}
+		if err := s.checkTags(tags); err != nil {
+			return nil, fmt.Errorf("item %d: %w", id, err)
+		}
+		targets = append(targets, item)
+		retagged = append(retagged, tags)
+	}
+
+	items := make([]*Item, len(targets))
+	changed := false
+	for i, item := range targets {
+		if !slices.Equal(item.Tags, retagged[i]) {
+			item.Tags = retagged[i]
+			item.UpdatedAt = s.now()
+			s.journalPut(item)
+			changed = true
+		}
+		items[i] = detach(item)
+	}
+	if changed {
+		s.touch(s.now())
+	}
+	return items, nil
+}
+
+// apply copies the set fields of update onto item, reporting whether any of
+// them changed. UpdatedAt only moves when something did. Callers must hold
+// the write lock.
+func (s *Store) apply(item *Item, update ItemUpdate) bool {
+	changed := false
+	if update.Name != nil && item.Name != *update.Name {
+		s.rename(item, *update.Name)
+		changed = true
+	}
+	if update.Completed != nil && item.Completed != *update.Completed {
+		s.setCompleted(item, *update.Completed)
+		changed = true
+	}
+	if update.EstimateMinutes != nil && item.EstimateMinutes != *update.EstimateMinutes {
+		item.EstimateMinutes = *update.EstimateMinutes
+		changed = true
+	}
+	if update.Tags != nil {
+		if tags := normalizeTags(*update.Tags); !slices.Equal(item.Tags, tags) {
+			item.Tags = tags
+			changed = true
+		}
+	}
+	if update.DueDate != nil && (item.DueDate == nil || !item.DueDate.Equal(*update.DueDate)) {
+		item.DueDate = update.DueDate
+		changed = true
+	}
+	if update.ExpiresAt != nil && (item.ExpiresAt == nil || !item.ExpiresAt.Equal(*update.ExpiresAt)) {
+		item.ExpiresAt = update.ExpiresAt
+		s.noteExpiry(item.ExpiresAt)
+		changed = true
+	}
+	if update.BlockedBy != nil {
+		if blockers := normalizeBlockers(*update.BlockedBy); !slices.Equal(item.BlockedBy, blockers) {
+			item.BlockedBy = blockers
+			item.Blocked = s.isBlocked(item)
+			changed = true
+		}
+	}
+	if changed {
+		item.UpdatedAt = s.now()
+	}
+	return changed
+}
+
+// setCompleted marks item done or pending, stamping CompletedAt when it
+// becomes done and clearing it when it's reopened. Callers must hold the write
+// lock.
+func (s *Store) setCompleted(item *Item, completed bool) {
+	item.Completed = completed
+	item.CompletedAt = nil
+	if completed {
+		now := s.now()
+		item.CompletedAt = &now
+		s.record(ActivityCompleted)
+	}
+	s.refreshBlocked(item.ID)
+}
+
+// Patch hands a copy of the item to fn and saves the writable fields of the
+// result, all under the write lock so concurrent patches can't interleave.
+// When expected is set, each of its JSON fields must still hold the given
+// value or the patch fails with ErrFieldConflict. Like Update, it reports
+// whether anything changed and leaves the item alone when nothing did.
+func (s *Store) Patch(id int, expected map[string]any, fn func(Item) (Item, error)) (*Item, bool, error) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[id]
+	if !ok {
+		return nil, false, ErrNotFound
+	}
+	if len(expected) > 0 {
+		if err := checkExpected(*item, expected); err != nil {
+			return nil, false, err
+		}
+	}
+
+	patched, err := fn(*item)
+	if err != nil {
+		return nil, false, err
+	}
+	if s.nameTaken(patched.Name, id) {
+		return nil, false, ErrDuplicateName
+	}
+	tags := normalizeTags(patched.Tags)
+	if err := s.checkTags(tags); err != nil {
+		return nil, false, err
+	}
+	blockers := normalizeBlockers(patched.BlockedBy)
+	if err := s.checkBlockers(id, blockers); err != nil {
+		return nil, false, err
+	}
+	if patched.Name == item.Name && patched.Completed == item.Completed &&
+		patched.EstimateMinutes == item.EstimateMinutes && slices.Equal(tags, item.Tags) &&
+		sameTime(patched.DueDate, item.DueDate) && sameTime(patched.ExpiresAt, item.ExpiresAt) &&
+		slices.Equal(blockers, item.BlockedBy) {
+		return detach(item), false, nil
+	}
+
+	if patched.Completed != item.Completed {
+		s.setCompleted(item, patched.Completed)
+	}
+	s.rename(item, patched.Name)
+	item.EstimateMinutes = patched.EstimateMinutes
+	item.Tags = tags
+	item.DueDate = patched.DueDate
+	item.ExpiresAt = patched.ExpiresAt
+	s.noteExpiry(item.ExpiresAt)
+	item.BlockedBy = blockers
+	item.Blocked = s.isBlocked(item)
+	item.UpdatedAt = s.now()
+	s.touch(item.UpdatedAt)
+	s.journalPut(item)
+	return detach(item), true, nil
+}
+
+// sameTime reports whether two optional times are both unset or equal.
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func (s *Store) Stats() Stats {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	var stats Stats
+	progress := 0
+	for _, item := range s.items {
+		stats.Total++
+		progress += item.Progress()
+		if item.Completed {
+			stats.Completed++
+			continue
+		}
+		stats.Pending++
+		stats.PendingEstimateMinutes += item.EstimateMinutes
+	}
+	if stats.Total > 0 {
+		stats.AverageProgress = float64(progress) / float64(stats.Total)
+	}
+	return stats
+}
+
+// TagCounts returns every tag in use with the number of items carrying it,
+// most used first.
+func (s *Store) TagCounts() []TagCount {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, item := range s.items {
+		for _, tag := range item.Tags {
+			counts[tag]++
+		}
+	}
+
+	result := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		result = append(result, TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Tag < result[j].Tag
+	})
+	return result
+}
+
+// Delete removes an item and returns it as it was at removal.
+func (s *Store) Delete(id int) (*Item, bool) {
+	s.lock()
+	defer s.mu.Unlock()
+	return s.remove(id)
+}
+
+// remove deletes an item. Callers must hold the write lock.
+func (s *Store) remove(id int) (*Item, bool) {
+	item, ok := s.items[id]
+	if ok {
+		delete(s.items, id)
+		delete(s.slugs, item.Slug)
+		s.unblock(id)
+		s.touch(s.now())
+		s.record(ActivityDeleted)
+		s.journalDelete(id)
+	}
+	return item, ok
+}
+
+// PurgeExpired removes every item whose ExpiresAt is not after now and
+// reports how many it removed. Reads and writes already treat expired items as
+// gone, so this only reclaims their memory between requests.
+func (s *Store) PurgeExpired(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.purgeExpired(now)
+}
+
+// purgeExpired is PurgeExpired for callers holding the write lock.
+func (s *Store) purgeExpired(now time.Time) int {
+	purged := 0
+	var next int64
+	for id, item := range s.items {
+		if item.ExpiresAt == nil {
+			continue
+		}
+		if !item.ExpiresAt.After(now) {
+			delete(s.items, id)
+			delete(s.slugs, item.Slug)
+			s.unblock(id)
+			s.record(ActivityDeleted)
+			s.journalDelete(id)
+			purged++
+			continue
+		}
+		if t := item.ExpiresAt.UnixNano(); next == 0 || t < next {
+			next = t
+		}
+	}
+	s.nextExpiry.Store(next)
+	if purged > 0 {
+		s.touch(now)
+	}
+	return purged
+}
+
+// noteExpiry brings nextExpiry forward to t if it is sooner. Callers must hold
+// the write lock.
+func (s *Store) noteExpiry(t *time.Time) {
+	if t == nil {
+		return
+	}
+	if next := s.nextExpiry.Load(); next == 0 || t.UnixNano() < next {
+		s.nextExpiry.Store(t.UnixNano())
+	}
+}
+
+// expiryDue reports whether some item may have expired since the last purge.
+func (s *Store) expiryDue() bool {
+	next := s.nextExpiry.Load()
+	return next != 0 && s.now().UnixNano() >= next
+}
+
+// rlock takes the read lock, first purging expired items if any are due, so
+// no read sees an item past its expiry.
+func (s *Store) rlock() {
+	if s.expiryDue() {
+		s.mu.Lock()
+		s.purgeExpired(s.now())
+		s.mu.Unlock()
+	}
+	s.mu.RLock()
+}
+
+// lock takes the write lock and purges expired items that are due, so a
+// write never finds one.
+func (s *Store) lock() {
+	s.mu.Lock()
+	if s.expiryDue() {
+		s.purgeExpired(s.now())
+	}
+}
+
+// OnChange registers fn to be called after every change to the store. It is
+// called with the write lock held, so it must return quickly and must not
+// call back into the store.
+func (s *Store) OnChange(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = append(s.onChange, fn)
+}
+
+// touch records a change made at t. Callers must hold the write lock.
+func (s *Store) touch(t time.Time) {
+	s.lastModified = t
+	s.revision++
+	for _, fn := range s.onChange {
+		fn()
+	}
+}
+
+// record appends an activity event, dropping events that have aged out of
+// maxActivityWindow or overflow maxActivityEvents. Callers must hold the
+// write lock.
+func (s *Store) record(kind ActivityKind) {
+	if s.txTotals != nil {
+		s.txTotals[kind]++
+	} else {
+		s.totals[kind].Add(1)
+	}
+
+	now := s.now()
+	s.activity = append(s.activity, activityEvent{kind: kind, at: now})
+
+	cutoff := now.Add(-maxActivityWindow)
+	drop := 0
+	for drop < len(s.activity) && s.activity[drop].at.Before(cutoff) {
+		drop++
+	}
+	if overflow := len(s.activity) - drop - maxActivityEvents; overflow > 0 {
+		drop += overflow
+	}
+	if drop > 0 {
+		s.activity = append(s.activity[:0], s.activity[drop:]...)
+	}
+}
+
+// Activity counts the events of the last window in consecutive buckets of
+// interval, oldest first.
+func (s *Store) Activity(window, interval time.Duration) []ActivityBucket {
+	s.rlock()
+	defer s.mu.RUnlock()
+
+	now := s.now()
+	start := now.Add(-window)
+	count := int((window + interval - 1) / interval)
+
+	buckets := make([]ActivityBucket, count)
+	for i := range buckets {
+		buckets[i].Start = start.Add(time.Duration(i) * interval)
+	}
+
+	for _, event := range s.activity {
+		if event.at.Before(start) || event.at.After(now) {
+			continue
+		}
+		i := int(event.at.Sub(start) / interval)
+		if i >= count {
+			i = count - 1
+		}
+		switch event.kind {
+		case ActivityCreated:
+			buckets[i].Created++
+		case ActivityCompleted:
+			buckets[i].Completed++
+		case ActivityDeleted:
+			buckets[i].Deleted++
+		}
+	}
+	return buckets
+}
+
+// Compact copies the store's maps and activity log into new ones sized for
+// what they hold now, since Go maps never give back the room deleted entries
+// took, and rewrites the WAL if there is one.
+func (s *Store) Compact() CompactResult {
+	s.lock()
+	defer s.mu.Unlock()
+
+	items := make(map[int]*Item, len(s.items))
+	for id, item := range s.items {
+		items[id] = item
+	}
+	s.items = items
+	slugs := make(map[string]int, len(s.slugs))
+	for slug, id := range s.slugs {
+		slugs[slug] = id
+	}
+	s.slugs = slugs
+	s.activity = slices.Clone(s.activity)
+
+	result := CompactResult{Items: len(s.items)}
+	if s.wal != nil {
+		result.WALEntriesBefore = s.wal.entries
+		s.compactWAL()
+		result.WALEntriesAfter = s.wal.entries
+	}
+	return result
+}
+
+// Totals returns the lifetime counts of created, completed and deleted items.
+// It takes no lock, so it is cheap to poll.
+func (s *Store) Totals() Totals {
+	return Totals{
+		Created:   s.totals[ActivityCreated].Load(),
+		Completed: s.totals[ActivityCompleted].Load(),
+		Deleted:   s.totals[ActivityDeleted].Load(),
+	}
+}
+
+// rename sets item's name, moving it to a new slug unless the new name gives
+// the same one. Callers must hold the write lock.
+func (s *Store) rename(item *Item, name string) {
+	if s.slugify(name) != s.slugify(item.Name) {
+		delete(s.slugs, item.Slug)
+		item.Name = name
+		s.assignSlug(item)
+		return
+	}
+	item.Name = name
+}
+
+// assignSlug gives item the slug of its name, adding -2, -3 and so on when
+// another item has it already. Callers must hold the write lock.
+func (s *Store) assignSlug(item *Item) {
+	base := s.slugify(item.Name)
+	slug := base
+	for n := 2; ; n++ {
+		if id, taken := s.slugs[slug]; !taken || id == item.ID {
+			break
+		}
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+	item.Slug = slug
+	s.slugs[slug] = item.ID
+}
+
+// indexSlugs rebuilds the slug index from the items. Callers must hold the
+// write lock.
+func (s *Store) indexSlugs() {
+	s.slugs = make(map[string]int, len(s.items))
+	for id, item := range s.items {
+		s.slugs[item.Slug] = id
+	}
+}
+
+// slugify lowercases name and strips its accents, keeping runs of letters and
+// digits joined by single hyphens. Names with none of either get "item".
+func (s *Store) slugify(name string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range norm.NFD.String(name) {
+		if s.maxSlugLen > 0 && n >= s.maxSlugLen {
+			break
+		}
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(unicode.ToLower(r))
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteByte('-')
+		default:
+			continue
+		}
+		n++
+	}
+	if slug := strings.TrimSuffix(b.String(), "-"); slug != "" {
+		return slug
+	}
+	return "item"
+}
+
+// checkBlockers fails with ErrInvalidBlockers unless every one of blockers is
+// an existing item other than id, and none of them is blocked by id, directly
+// or through other items, as that would make a cycle. Callers must hold the
+// lock.
+func (s *Store) checkBlockers(id int, blockers []int) error {
+	for _, blocker := range blockers {
+		if blocker == id {
+			return fmt.Errorf("%w: an item can't block itself", ErrInvalidBlockers)
+		}
+		if _, ok := s.items[blocker]; !ok {
+			return fmt.Errorf("%w: item %d doesn't exist", ErrInvalidBlockers, blocker)
+		}
+	}
+	if id == 0 {
+		// A new item has nothing depending on it yet.
+		return nil
+	}
+
+	// Walk depth-first from each blocker down to what blocks it; reaching id
+	// means id would end up waiting on itself.
+	seen := make(map[int]bool)
+	for _, blocker := range blockers {
+		stack := []int{blocker}
+		for len(stack) > 0 {
+			next := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if next == id {
+				return fmt.Errorf("%w: item %d already depends on item %d, which would make a cycle", ErrInvalidBlockers, blocker, id)
+			}
+			if seen[next] {
+				continue
+			}
+			seen[next] = true
+			if item, ok := s.items[next]; ok {
+				stack = append(stack, item.BlockedBy...)
+			}
+		}
+	}
+	return nil
+}
+
+// isBlocked reports whether any of item's blockers is pending. Callers must
+// hold the lock.
+func (s *Store) isBlocked(item *Item) bool {
+	for _, id := range item.BlockedBy {
+		if blocker, ok := s.items[id]; ok && !blocker.Completed {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshBlocked recomputes Blocked for the items blocked by id, after it was
+// created, completed or reopened. Callers must hold the write lock.
+func (s *Store) refreshBlocked(id int) {
+	for _, item := range s.items {
+		if slices.Contains(item.BlockedBy, id) {
+			item.Blocked = s.isBlocked(item)
+		}
+	}
+}
+
+// unblock drops the removed item id from every BlockedBy, so no reference
+// outlives it to bind to a later item created under the same ID. The items it
+// edits are logged but keep their UpdatedAt, as no client changed them.
+// Callers must hold the write lock.
+func (s *Store) unblock(id int) {
+	for _, item := range s.items {
+		if i := slices.Index(item.BlockedBy, id); i >= 0 {
+			item.BlockedBy = slices.Delete(slices.Clone(item.BlockedBy), i, i+1)
+			if len(item.BlockedBy) == 0 {
+				item.BlockedBy = nil
+			}
+			item.Blocked = s.isBlocked(item)
+			s.journalPut(item)
+		}
+	}
+}
+
+// indexBlocked recomputes Blocked for every item, after changes made
+// without refreshBlocked. Callers must hold the write lock.
+func (s *Store) indexBlocked() {
+	for _, item := range s.items {
+		item.Blocked = s.isBlocked(item)
+	}
+}
+
+// checkTags fails with ErrInvalidTags when normalized tags break the tag
+// limits.
+func (s *Store) checkTags(tags []string) error {
+	if s.maxTags > 0 && len(tags) > s.maxTags {
+		return fmt.Errorf("%w: an item can have at most %d tags", ErrInvalidTags, s.maxTags)
+	}
+	if s.maxTagLength > 0 {
+		for _, tag := range tags {
+			if utf8.RuneCountInString(tag) > s.maxTagLength {
+				return fmt.Errorf("%w: tag %q is longer than %d characters", ErrInvalidTags, tag, s.maxTagLength)
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeBlockers sorts blocker IDs and drops repeats, returning nil for
+// none.
+func normalizeBlockers(ids []int) []int {
+	if len(ids) == 0 {
+		return nil
+	}
+	return slices.Compact(slices.Sorted(slices.Values(ids)))
+}
+
+// normalizeTags lowercases and trims tags, dropping blanks and duplicates.
+func normalizeTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(tags))
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	sort.Strings(result)
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
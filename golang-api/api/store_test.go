@@ -0,0 +1,340 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newBlockerStore returns a store holding items 1 to n, none blocked.
+func newBlockerStore(t *testing.T, n int) *Store {
+	t.Helper()
+	s := NewStore()
+	for i := range n {
+		if _, err := s.Create(ItemInput{Name: string(rune('a' + i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func setBlockers(s *Store, id int, blockers ...int) error {
+	_, _, err := s.Update(id, ItemUpdate{BlockedBy: &blockers})
+	return err
+}
+
+func TestCheckBlockersRejectsCycles(t *testing.T) {
+	tests := []struct {
+		name string
+		// edges are set in order, each item to its blockers, before the
+		// update under test.
+		edges    map[int][]int
+		id       int
+		blockers []int
+		wantErr  bool
+	}{
+		{name: "self", id: 1, blockers: []int{1}, wantErr: true},
+		{name: "self among others", id: 1, blockers: []int{2, 1}, wantErr: true},
+		{name: "direct", edges: map[int][]int{2: {1}}, id: 1, blockers: []int{2}, wantErr: true},
+		{name: "transitive", edges: map[int][]int{2: {1}, 3: {2}, 4: {3}}, id: 1, blockers: []int{4}, wantErr: true},
+		{name: "chain", edges: map[int][]int{2: {1}, 3: {2}}, id: 4, blockers: []int{3}},
+		{name: "diamond", edges: map[int][]int{2: {1}, 3: {1}}, id: 4, blockers: []int{2, 3}},
+		{name: "unknown blocker", id: 1, blockers: []int{99}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newBlockerStore(t, 4)
+			for _, id := range []int{1, 2, 3, 4} {
+				if blockers, ok := tt.edges[id]; ok {
+					if err := setBlockers(s, id, blockers...); err != nil {
+						t.Fatalf("setting up %d: %v", id, err)
+					}
+				}
+			}
+			err := setBlockers(s, tt.id, tt.blockers...)
+			if gotErr := errors.Is(err, ErrInvalidBlockers); gotErr != tt.wantErr {
+				t.Fatalf("err = %v, want ErrInvalidBlockers: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBlockedFollowsBlockers(t *testing.T) {
+	s := newBlockerStore(t, 3)
+	if err := setBlockers(s, 3, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	blocked := func() bool {
+		item, _ := s.Get(3)
+		return item.Blocked
+	}
+	if !blocked() {
+		t.Fatal("item with pending blockers isn't blocked")
+	}
+
+	done := true
+	s.Update(1, ItemUpdate{Completed: &done})
+	if !blocked() {
+		t.Fatal("item unblocked with a blocker still pending")
+	}
+	s.Update(2, ItemUpdate{Completed: &done})
+	if blocked() {
+		t.Fatal("item still blocked after every blocker completed")
+	}
+	pending := false
+	s.Update(2, ItemUpdate{Completed: &pending})
+	if !blocked() {
+		t.Fatal("item not blocked again after a blocker reopened")
+	}
+}
+
+func TestDeleteDropsBlocker(t *testing.T) {
+	s := newBlockerStore(t, 2)
+	if err := setBlockers(s, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	s.Delete(1)
+	if item, _ := s.Get(2); item.BlockedBy != nil || item.Blocked {
+		t.Fatalf("after deleting its blocker, item has blockedBy %v, blocked %t", item.BlockedBy, item.Blocked)
+	}
+
+	// A new item under the deleted ID must not inherit the old reference.
+	if _, err := s.CreateWithID(1, ItemInput{Name: "again"}); err != nil {
+		t.Fatal(err)
+	}
+	if item, _ := s.Get(2); item.Blocked {
+		t.Fatal("item blocked by an item created under a deleted blocker's ID")
+	}
+}
+
+func TestRolledBackDeleteKeepsBlockers(t *testing.T) {
+	s := newBlockerStore(t, 2)
+	if err := setBlockers(s, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	s.WithTx(func(tx StoreTx) error {
+		tx.Delete(1)
+		return errors.New("roll back")
+	})
+	item, _ := s.Get(2)
+	if !slices.Equal(item.BlockedBy, []int{1}) || !item.Blocked {
+		t.Fatalf("after rollback, item has blockedBy %v, blocked %t", item.BlockedBy, item.Blocked)
+	}
+}
+
+func TestCreateNeverReusesDeletedIDs(t *testing.T) {
+	s := newBlockerStore(t, 3)
+	if _, ok := s.Delete(3); !ok {
+		t.Fatal("Delete(3) found nothing")
+	}
+
+	item, err := s.Create(ItemInput{Name: "next"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.ID != 4 {
+		t.Errorf("Create after deleting 3 got ID %d, want 4", item.ID)
+	}
+
+	// Only an explicit ID brings a deleted one back.
+	if item, err = s.CreateWithID(3, ItemInput{Name: "again"}); err != nil {
+		t.Fatal(err)
+	}
+	if item.ID != 3 {
+		t.Errorf("CreateWithID(3) got ID %d", item.ID)
+	}
+	if item, _ = s.Create(ItemInput{Name: "after"}); item.ID != 5 {
+		t.Errorf("Create after recreating 3 got ID %d, want 5", item.ID)
+	}
+}
+
+// TestConcurrentPatchesLoseNothing has every patch read the item and write
+// back a change built on what it read. Patch runs fn under the write lock, so
+// each sees the ones before it and none is lost.
+func TestConcurrentPatchesLoseNothing(t *testing.T) {
+	s := newBlockerStore(t, 1)
+
+	const writers = 50
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := s.Patch(1, nil, func(item Item) (Item, error) {
+				item.EstimateMinutes++
+				if i%2 == 0 {
+					item.Tags = append(item.Tags, fmt.Sprintf("t%d", i))
+				}
+				return item, nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	item, ok := s.Get(1)
+	if !ok {
+		t.Fatal("item 1 is gone")
+	}
+	if item.EstimateMinutes != writers {
+		t.Errorf("EstimateMinutes = %d after %d increments", item.EstimateMinutes, writers)
+	}
+	if len(item.Tags) != writers/2 {
+		t.Errorf("%d tags after %d patches each added one: %v", len(item.Tags), writers/2, item.Tags)
+	}
+}
+
+// TestConcurrentUpdatesApplyWhole races updates that each set two fields to
+// values that belong together. Update applies both under one lock, so the
+// item always ends up with a matching pair.
+func TestConcurrentUpdatesApplyWhole(t *testing.T) {
+	s := newBlockerStore(t, 1)
+
+	const writers = 50
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name, estimate := fmt.Sprintf("writer %d", i), i
+			if _, _, err := s.Update(1, ItemUpdate{Name: &name, EstimateMinutes: &estimate}); err != nil {
+				t.Error(err)
+			}
+			item, _ := s.Get(1)
+			if item.Name != fmt.Sprintf("writer %d", item.EstimateMinutes) {
+				t.Errorf("torn update: name %q with estimate %d", item.Name, item.EstimateMinutes)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestIDsRunOutWithoutWrapping(t *testing.T) {
+	s := newBlockerStore(t, 1)
+
+	// The highest ID create may allocate is math.MaxInt-1; using it leaves
+	// none for Create, which fails rather than wrapping round.
+	if _, err := s.CreateWithID(math.MaxInt-1, ItemInput{Name: "last"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(ItemInput{Name: "one more"}); !errors.Is(err, ErrIDsExhausted) {
+		t.Fatalf("Create with no IDs left = %v, want ErrIDsExhausted", err)
+	}
+	if _, err := s.CreateMany([]ItemInput{{Name: "x"}}); !errors.Is(err, ErrIDsExhausted) {
+		t.Errorf("CreateMany with no IDs left = %v, want ErrIDsExhausted", err)
+	}
+
+	// math.MaxInt itself can only be asked for, and only once.
+	item, err := s.CreateWithID(math.MaxInt, ItemInput{Name: "max"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.ID != math.MaxInt {
+		t.Errorf("CreateWithID(math.MaxInt) got ID %d", item.ID)
+	}
+	if _, err := s.CreateWithID(math.MaxInt, ItemInput{Name: "max again"}); !errors.Is(err, ErrItemExists) {
+		t.Errorf("second CreateWithID(math.MaxInt) = %v, want ErrItemExists", err)
+	}
+	if _, err := s.Create(ItemInput{Name: "after max"}); !errors.Is(err, ErrIDsExhausted) {
+		t.Errorf("Create after math.MaxInt = %v, want ErrIDsExhausted", err)
+	}
+
+	if n := s.Count(Filter{}); n != 3 {
+		t.Errorf("%d items, want 1, math.MaxInt-1 and math.MaxInt", n)
+	}
+
+	// Reset starts the IDs over.
+	s.Reset(nil)
+	if item, err := s.Create(ItemInput{Name: "fresh"}); err != nil || item.ID != 1 {
+		t.Errorf("Create after Reset = %v, %v, want ID 1", item, err)
+	}
+}
+
+func TestStoreOptionsCompose(t *testing.T) {
+	now := timeIDEpoch.Add(time.Hour)
+	s := NewStore(
+		WithCapacity(1), // overridden below: the last option wins
+		WithClock(func() time.Time { return now }),
+		WithCapacity(3),
+		WithTagLimits(2, 4),
+		WithSlugLength(5),
+		WithUniqueScope(UniqueGlobal),
+		WithIDGenerator(TimeIDs{}),
+	)
+
+	item, err := s.Create(ItemInput{Name: "Write the report", Tags: []string{"work", "q3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 3600 << timeIDSequenceBits; item.ID != want {
+		t.Errorf("ID = %d, want the time ID %d", item.ID, want)
+	}
+	if !item.CreatedAt.Equal(now) {
+		t.Errorf("CreatedAt = %s, want the store clock's %s", item.CreatedAt, now)
+	}
+	if item.Slug != "write" {
+		t.Errorf("slug = %q, want it cut to 5 characters", item.Slug)
+	}
+
+	if _, err := s.Create(ItemInput{Name: "WRITE THE REPORT"}); !errors.Is(err, ErrDuplicateName) {
+		t.Errorf("duplicate name = %v, want ErrDuplicateName", err)
+	}
+	if _, err := s.Create(ItemInput{Name: "tags", Tags: []string{"a", "b", "c"}}); !errors.Is(err, ErrInvalidTags) {
+		t.Errorf("three tags = %v, want ErrInvalidTags", err)
+	}
+	if _, err := s.Create(ItemInput{Name: "long tag", Tags: []string{"lengthy"}}); !errors.Is(err, ErrInvalidTags) {
+		t.Errorf("five-character tag = %v, want ErrInvalidTags", err)
+	}
+	for _, name := range []string{"second", "third"} {
+		if _, err := s.Create(ItemInput{Name: name}); err != nil {
+			t.Fatalf("create %q under capacity: %v", name, err)
+		}
+	}
+	if _, err := s.Create(ItemInput{Name: "fourth"}); !errors.Is(err, ErrCapacityReached) {
+		t.Errorf("create over capacity = %v, want ErrCapacityReached", err)
+	}
+}
+
+func TestBulkUpdateRefusesBlockers(t *testing.T) {
+	s := newBlockerStore(t, 3)
+
+	// Setting item 1 as every item's blocker would make it block itself.
+	blockers := []int{1}
+	if _, err := s.BulkUpdate(Filter{}, ItemUpdate{BlockedBy: &blockers}); !errors.Is(err, ErrInvalidBlockers) {
+		t.Fatalf("BulkUpdate with blockers = %v, want ErrInvalidBlockers", err)
+	}
+	for id := 1; id <= 3; id++ {
+		if item, _ := s.Get(id); len(item.BlockedBy) != 0 {
+			t.Errorf("item %d blocked by %v after the refused update", id, item.BlockedBy)
+		}
+	}
+
+	completed := true
+	if n, err := s.BulkUpdate(Filter{}, ItemUpdate{Completed: &completed}); err != nil || n != 3 {
+		t.Errorf("BulkUpdate without blockers = %d, %v, want 3 updated", n, err)
+	}
+}
+
+// TestActivityCountsDueExpiries checks that Activity purges like every other
+// read, so an item past its expiry is counted as deleted before the sweep
+// gets to it.
+func TestActivityCountsDueExpiries(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := NewStore(WithClock(func() time.Time { return now }))
+	expires := now.Add(time.Minute)
+	if _, err := s.Create(ItemInput{Name: "temporary", ExpiresAt: &expires}); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	buckets := s.Activity(time.Hour, time.Hour)
+	if len(buckets) != 1 || buckets[0].Created != 1 || buckets[0].Deleted != 1 {
+		t.Errorf("Activity = %+v, want 1 created and 1 deleted", buckets)
+	}
+}
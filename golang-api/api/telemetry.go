@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// otelMetrics exports request and item metrics over OTLP, which is how the
+// Aspire dashboard receives them. The exporter reads the standard OTEL_*
+// variables Aspire injects, and OTEL_SERVICE_NAME names the resource.
+type otelMetrics struct {
+	provider *sdkmetric.MeterProvider
+	requests metric.Int64Counter
+	duration metric.Float64Histogram
+}
+
+func newOTelMetrics(ctx context.Context, store ItemStore) (*otelMetrics, error) {
+	exporter, err := otlpmetricgrpc.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)))
+	meter := provider.Meter("api")
+
+	m := &otelMetrics{provider: provider}
+	if m.requests, err = meter.Int64Counter("http.server.requests",
+		metric.WithDescription("HTTP requests served."),
+		metric.WithUnit("{request}")); err != nil {
+		return nil, err
+	}
+	if m.duration, err = meter.Float64Histogram("http.server.request.duration",
+		metric.WithDescription("Duration of HTTP server requests."),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if _, err = meter.Int64ObservableGauge("items",
+		metric.WithDescription("Items currently in the store."),
+		metric.WithUnit("{item}"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(store.Stats().Total))
+			return nil
+		})); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// middleware records each request under its route pattern rather than its
+// path, so /items/1 and /items/2 share a series.
+func (m *otelMetrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		attrs := metric.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("http.route", chi.RouteContext(r.Context()).RoutePattern()),
+			attribute.Int("http.response.status_code", status),
+		)
+		m.requests.Add(r.Context(), 1, attrs)
+		m.duration.Record(r.Context(), time.Since(start).Seconds(), attrs)
+	})
+}
+
+// shutdown flushes the metrics not yet exported.
+func (m *otelMetrics) shutdown(ctx context.Context) error {
+	return m.provider.Shutdown(ctx)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// defaultRouteTimeouts override REQUEST_TIMEOUT for routes whose requests are
+// meant to last, keyed by chi route pattern. Zero means no limit. ROUTE_TIMEOUTS
+// adds to and overrides them.
+var defaultRouteTimeouts = map[string]time.Duration{
+	// Event streams stay open until the client leaves.
+	"/items/events":      0,
+	"/items/{id}/events": 0,
+}
+
+// parseRouteTimeouts reads PATTERN=DURATION entries separated by semicolons,
+// such as "/items/stream=2m;/items/{id}=2s", over the defaults. A duration of
+// 0 lifts the limit for that route.
+func parseRouteTimeouts(v string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration, len(defaultRouteTimeouts))
+	for pattern, timeout := range defaultRouteTimeouts {
+		timeouts[pattern] = timeout
+	}
+	for _, entry := range strings.Split(v, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		pattern, value, ok := strings.Cut(entry, "=")
+		pattern, value = strings.TrimSpace(pattern), strings.TrimSpace(value)
+		timeout, err := time.ParseDuration(value)
+		if !ok || !strings.HasPrefix(pattern, "/") || err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid ROUTE_TIMEOUTS entry %q: must be a route pattern=duration such as /items/stream=2m", entry)
+		}
+		timeouts[pattern] = timeout
+	}
+	return timeouts, nil
+}
+
+// hasTimeouts reports whether any route ends up with a limit.
+func hasTimeouts(global time.Duration, overrides map[string]time.Duration) bool {
+	if global > 0 {
+		return true
+	}
+	for _, timeout := range overrides {
+		if timeout > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// routeTimeouts gives each request a deadline: its route's override or, when
+// it has none, the global timeout. Handlers stop at the
+// deadline by watching the request context; one that hits it without having
+// responded is answered with a 504.
+func routeTimeouts(mux *chi.Mux, global time.Duration, overrides map[string]time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout, ok := overrides[findRoute(mux, r)]
+			if !ok {
+				timeout = global
+			}
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && ww.Status() == 0 {
+				http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRouteTimeoutOverrides(t *testing.T) {
+	// slow answers after 200ms unless its deadline comes first.
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(200 * time.Millisecond):
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+	mux := chi.NewRouter()
+	mux.Get("/quick/{id}", slow)
+	mux.Get("/export", slow)
+	mux.Get("/other", slow)
+
+	overrides, err := parseRouteTimeouts("/quick/{id}=20ms;/export=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := routeTimeouts(mux, 100*time.Millisecond, overrides)(mux)
+
+	tests := []struct {
+		path   string
+		status int
+		under  time.Duration
+	}{
+		{"/quick/7", http.StatusGatewayTimeout, 100 * time.Millisecond},
+		{"/other", http.StatusGatewayTimeout, 200 * time.Millisecond},
+		{"/export", http.StatusOK, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			start := time.Now()
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if took := time.Since(start); rec.Code != tt.status || took >= tt.under {
+				t.Errorf("%s = %d after %s, want %d in under %s", tt.path, rec.Code, took, tt.status, tt.under)
+			}
+		})
+	}
+}
+
+func TestParseRouteTimeouts(t *testing.T) {
+	got, err := parseRouteTimeouts(" /items/{id}=2s ; /items/events=1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["/items/{id}"] != 2*time.Second || got["/items/events"] != time.Minute || got["/items/{id}/events"] != 0 {
+		t.Errorf("parseRouteTimeouts = %v", got)
+	}
+	for _, bad := range []string{"items=2s", "/items", "/items=-1s", "/items=soon"} {
+		if _, err := parseRouteTimeouts(bad); err == nil {
+			t.Errorf("parseRouteTimeouts(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// StoreTx is the view of the store given to a WithTx closure. Its methods
+// behave like the Store methods of the same name, and it must not be used
+// once the closure returns.
+type StoreTx interface {
+	Get(id int) (*Item, bool)
+	Create(in ItemInput) (*Item, error)
+	Update(id int, update ItemUpdate) (*Item, bool, error)
+	Delete(id int) (*Item, bool)
+}
+
+// storeTx records how to undo each change it makes, so a failed transaction
+// can put the store back as it found it.
+type storeTx struct {
+	s    *Store
+	undo []func()
+
+	nextID       int
+	lastModified time.Time
+	activity     []activityEvent
+}
+
+// WithTx runs fn with the write lock held throughout, so the transaction is
+// serializable: no other read or write can observe its intermediate states,
+// and it sees no one else's. If fn returns an error or panics, every change it
+// made is rolled back, including the IDs it used.
+func (s *Store) WithTx(fn func(tx StoreTx) error) error {
+	s.lock()
+	defer s.mu.Unlock()
+
+	tx := &storeTx{
+		s:            s,
+		nextID:       s.nextID,
+		lastModified: s.lastModified,
+		activity:     slices.Clone(s.activity),
+	}
+	committed := false
+	s.beginWAL()
+	s.txTotals = new([ActivityDeleted + 1]int64)
+	defer func() {
+		if !committed {
+			tx.rollback()
+		}
+		s.endWAL(committed)
+		s.endTotals(committed)
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+func (tx *storeTx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	tx.s.indexSlugs()
+	tx.s.indexBlocked()
+	tx.s.nextID = tx.nextID
+	tx.s.touch(tx.lastModified)
+	tx.s.activity = tx.activity
+}
+
+// endTotals adds a committed transaction's activity to the totals and drops
+// a rolled-back one's.
+func (s *Store) endTotals(committed bool) {
+	pending := s.txTotals
+	s.txTotals = nil
+	if committed {
+		for kind, n := range pending {
+			s.totals[kind].Add(n)
+		}
+	}
+}
+
+func (tx *storeTx) Get(id int) (*Item, bool) {
+	item, ok := tx.s.items[id]
+	if !ok {
+		return nil, false
+	}
+	return detach(item), true
+}
+
+func (tx *storeTx) Create(in ItemInput) (*Item, error) {
+	item, err := tx.s.create(in)
+	if err != nil {
+		return nil, err
+	}
+	tx.undo = append(tx.undo, func() { delete(tx.s.items, item.ID) })
+	return item, nil
+}
+
+func (tx *storeTx) Update(id int, update ItemUpdate) (*Item, bool, error) {
+	item, ok := tx.s.items[id]
+	if !ok {
+		return nil, false, ErrNotFound
+	}
+	// Updates replace the tags slice rather than editing it, so a shallow
+	// copy is enough to restore from.
+	saved := *item
+	updated, changed, err := tx.s.update(id, update)
+	if err != nil {
+		return nil, false, err
+	}
+	tx.undo = append(tx.undo, func() { *item = saved })
+	return updated, changed, nil
+}
+
+func (tx *storeTx) Delete(id int) (*Item, bool) {
+	// Removing the item also drops it from other items' BlockedBy, which
+	// replaces those slices, so keeping the old ones is enough to restore.
+	blockedBy := make(map[int][]int)
+	for other, item := range tx.s.items {
+		if slices.Contains(item.BlockedBy, id) {
+			blockedBy[other] = item.BlockedBy
+		}
+	}
+	item, ok := tx.s.remove(id)
+	if ok {
+		tx.undo = append(tx.undo, func() {
+			tx.s.items[id] = item
+			for other, ids := range blockedBy {
+				tx.s.items[other].BlockedBy = ids
+			}
+		})
+	}
+	return item, ok
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+//go:embed schemas/*.json
+var schemaFS embed.FS
+
+// loadSchemas compiles the embedded request schemas, keyed by file name
+// without the .json extension.
+func loadSchemas() (map[string]*jsonschema.Schema, error) {
+	names, err := fs.Glob(schemaFS, "schemas/*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	c := jsonschema.NewCompiler()
+	c.AssertFormat()
+	for _, name := range names {
+		f, err := schemaFS.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := jsonschema.UnmarshalJSON(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if err := c.AddResource(name, doc); err != nil {
+			return nil, err
+		}
+	}
+
+	schemas := make(map[string]*jsonschema.Schema, len(names))
+	for _, name := range names {
+		sch, err := c.Compile(name)
+		if err != nil {
+			return nil, err
+		}
+		schemas[strings.TrimSuffix(path.Base(name), ".json")] = sch
+	}
+	return schemas, nil
+}
+
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validateBody checks body against sch and lists every violation found. The
+// error is only set when body isn't JSON at all.
+func validateBody(sch *jsonschema.Schema, body []byte) ([]Violation, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	err = sch.Validate(doc)
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return nil, nil
+	}
+
+	return violationsOf(verr), nil
+}
+
+// violationsOf lists the leaves of a validation error tree. Inner nodes such
+// as allOf and $ref only summarize the failures beneath them.
+func violationsOf(verr *jsonschema.ValidationError) []Violation {
+	if len(verr.Causes) > 0 {
+		var violations []Violation
+		for _, cause := range verr.Causes {
+			violations = append(violations, violationsOf(cause)...)
+		}
+		return violations
+	}
+
+	field := "/"
+	if len(verr.InstanceLocation) > 0 {
+		tokens := make([]string, len(verr.InstanceLocation))
+		for i, token := range verr.InstanceLocation {
+			tokens[i] = pointerEscaper.Replace(token)
+		}
+		field = "/" + strings.Join(tokens, "/")
+	}
+	return []Violation{{Field: field, Message: verr.ErrorKind.LocalizedString(messages)}}
+}
+
+// pointerEscaper escapes a JSON Pointer reference token (RFC 6901).
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+var messages = message.NewPrinter(language.English)
+
+// setsID reports whether body is a JSON object with an id member. An item's ID
+// only ever comes from the URL or the store.
+func setsID(body []byte) bool {
+	var members map[string]json.RawMessage
+	if json.Unmarshal(body, &members) != nil {
+		return false
+	}
+	_, ok := members["id"]
+	return ok
+}
+
+// errIDReadOnly rejects a body that sets id; the schemas would only call it an
+// unknown member.
+var errIDReadOnly = errors.New("id is read-only; use the URL")
+
+// entrySettingID returns the index of the first entry of a JSON array body
+// that sets id, or -1 when none does.
+func entrySettingID(body []byte) int {
+	var entries []json.RawMessage
+	if json.Unmarshal(body, &entries) != nil {
+		return -1
+	}
+	for i, entry := range entries {
+		if setsID(entry) {
+			return i
+		}
+	}
+	return -1
+}
+
+// maxBodyBytes caps how many bytes of a request body are read; maxJSONDepth
+// and maxJSONElements bound how deeply it nests and how many elements any one
+// of its arrays or objects holds. They are set once from MAX_BODY_BYTES,
+// MAX_JSON_DEPTH and MAX_JSON_ELEMENTS at startup.
+var (
+	maxBodyBytes    int64 = defaultMaxBodyBytes
+	maxJSONDepth          = defaultMaxJSONDepth
+	maxJSONElements       = defaultMaxJSONElements
+)
+
+// readBody reads at most maxBodyBytes of the request body and checks it
+// against the JSON limits before anything decodes it. It writes the error
+// response itself, 413 for a body over the cap, and reports false when the
+// handler should stop.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	if err := checkJSONLimits(body, maxJSONDepth, maxJSONElements); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+// checkJSONLimits walks body token by token, so a deeply nested or huge
+// document is refused without building it in memory. Malformed JSON passes;
+// the decoder that follows reports it.
+func checkJSONLimits(body []byte, maxDepth, maxElements int) error {
+	type container struct {
+		object bool
+		tokens int // keys and values, for objects
+	}
+	var open []container
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		if tok == json.Delim('}') || tok == json.Delim(']') {
+			open = open[:len(open)-1]
+			continue
+		}
+		if len(open) > 0 {
+			parent := &open[len(open)-1]
+			parent.tokens++
+			elements := parent.tokens
+			if parent.object {
+				elements = (parent.tokens + 1) / 2
+			}
+			if elements > maxElements {
+				return fmt.Errorf("request body has an array or object of more than %d elements", maxElements)
+			}
+		}
+		if tok == json.Delim('{') || tok == json.Delim('[') {
+			open = append(open, container{object: tok == json.Delim('{')})
+			if len(open) > maxDepth {
+				return fmt.Errorf("request body nests deeper than %d levels", maxDepth)
+			}
+		}
+	}
+}
+
+// decodeValidated reads the request body, validates it against sch and
+// decodes it into v. It writes the error response itself and reports false
+// when the handler should stop.
+func decodeValidated(w http.ResponseWriter, r *http.Request, sch *jsonschema.Schema, v any) bool {
+	body, ok := readBody(w, r)
+	if !ok {
+		return false
+	}
+	if !validateRaw(w, sch, body) {
+		return false
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// validateRaw is decodeValidated for handlers that need the raw body.
+func validateRaw(w http.ResponseWriter, sch *jsonschema.Schema, body []byte) bool {
+	if setsID(body) {
+		http.Error(w, errIDReadOnly.Error(), http.StatusBadRequest)
+		return false
+	}
+	violations, err := validateBody(sch, body)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	if len(violations) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error":      "validation failed",
+			"violations": violations,
+		})
+		return false
+	}
+	return true
+}
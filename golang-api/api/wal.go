@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+)
+
+// walEntry is one line of the write-ahead log: the state an item was left in,
+// the removal of one, or the store emptied. NextID is the store's after the
+// change, so IDs stay unused after a restart.
+type walEntry struct {
+	Op     string `json:"op"`
+	Item   *Item  `json:"item,omitempty"`
+	ID     int    `json:"id,omitempty"`
+	NextID int    `json:"nextId"`
+}
+
+const (
+	walPut    = "put"
+	walDelete = "delete"
+	walReset  = "reset"
+)
+
+// wal appends the store's changes to a file of JSON lines, which OpenWAL
+// replays on startup. Once it holds compactAfter entries more than the one put
+// per item a fresh log needs, it is rewritten as that.
+type wal struct {
+	path         string
+	f            *os.File
+	sync         bool
+	compactAfter int
+	entries      int // lines in the file
+
+	// pending holds a transaction's entries until it commits.
+	pending []walEntry
+	inTx    bool
+}
+
+// OpenWAL replays the log at path into the store, creating the file if it
+// doesn't exist, and logs every later change to it. A line that can't be read,
+// such as one a crash left half written, ends the replay: it and anything
+// after it are truncated away. It reports how many entries were replayed, so
+// a caller can tell a new log from a restored one.
+func (s *Store) OpenWAL(path string, compactAfter int, sync bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	replayed, valid, err := s.replay(f)
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if end, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return 0, err
+	} else if end > valid {
+		log.Printf("WAL %s: truncating a corrupt entry after line %d, dropping %d bytes", path, replayed, end-valid)
+		if err := f.Truncate(valid); err != nil {
+			f.Close()
+			return 0, err
+		}
+		if _, err := f.Seek(valid, io.SeekStart); err != nil {
+			f.Close()
+			return 0, err
+		}
+	}
+
+	s.wal = &wal{path: path, f: f, sync: sync, compactAfter: compactAfter, entries: replayed}
+	return replayed, nil
+}
+
+// replay applies the log's entries to the store and returns how many it read
+// and the offset just past the last good one. Callers must hold the write
+// lock.
+func (s *Store) replay(r io.Reader) (int, int64, error) {
+	br := bufio.NewReader(r)
+	replayed, valid := 0, int64(0)
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A last line without its newline was cut short.
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		var e walEntry
+		if json.Unmarshal(line, &e) != nil || !s.replayEntry(e) {
+			break
+		}
+		replayed++
+		valid += int64(len(line))
+	}
+
+	s.indexSlugs()
+	s.indexBlocked()
+	s.nextExpiry.Store(0)
+	for _, item := range s.items {
+		s.noteExpiry(item.ExpiresAt)
+	}
+	s.touch(s.now())
+	return replayed, valid, nil
+}
+
+// replayEntry applies one entry, reporting false for one it doesn't
+// understand.
+func (s *Store) replayEntry(e walEntry) bool {
+	switch {
+	case e.Op == walPut && e.Item != nil:
+		s.items[e.Item.ID] = e.Item
+	case e.Op == walDelete:
+		delete(s.items, e.ID)
+	case e.Op == walReset:
+		s.items = make(map[int]*Item)
+	default:
+		return false
+	}
+	s.nextID = e.NextID
+	return true
+}
+
+// journal logs a change, compacting the log once it is due. Write errors are
+// logged and the store carries on, as the change has already been made.
+// Callers must hold the write lock.
+func (s *Store) journal(e walEntry) {
+	if s.wal == nil {
+		return
+	}
+	e.NextID = s.nextID
+	if e.Item != nil {
+		e.Item = detach(e.Item)
+	}
+	if s.wal.inTx {
+		s.wal.pending = append(s.wal.pending, e)
+		return
+	}
+	s.writeWAL(e)
+}
+
+func (s *Store) journalPut(item *Item) {
+	s.journal(walEntry{Op: walPut, Item: item})
+}
+
+func (s *Store) journalDelete(id int) {
+	s.journal(walEntry{Op: walDelete, ID: id})
+}
+
+// writeWAL appends entries to the log in a single write.
+func (s *Store) writeWAL(entries ...walEntry) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			log.Printf("Writing WAL %s: %v", s.wal.path, err)
+			return
+		}
+	}
+	if _, err := s.wal.f.Write(buf.Bytes()); err != nil {
+		log.Printf("Writing WAL %s: %v", s.wal.path, err)
+		return
+	}
+	if s.wal.sync {
+		if err := s.wal.f.Sync(); err != nil {
+			log.Printf("Syncing WAL %s: %v", s.wal.path, err)
+		}
+	}
+	s.wal.entries += len(entries)
+	if s.wal.compactAfter > 0 && s.wal.entries >= s.wal.compactAfter+len(s.items) {
+		s.compactWAL()
+	}
+}
+
+// beginWAL holds back entries until endWAL, so a transaction that rolls back
+// leaves no trace in the log. Callers must hold the write lock.
+func (s *Store) beginWAL() {
+	if s.wal != nil {
+		s.wal.inTx = true
+	}
+}
+
+// endWAL writes the held-back entries if the transaction committed and drops
+// them if it didn't.
+func (s *Store) endWAL(committed bool) {
+	if s.wal == nil {
+		return
+	}
+	pending := s.wal.pending
+	s.wal.pending, s.wal.inTx = nil, false
+	if committed && len(pending) > 0 {
+		s.writeWAL(pending...)
+	}
+}
+
+// compactWAL rewrites the log as a reset followed by one put per item. The new
+// file replaces the old one by rename, so a crash midway leaves one or the
+// other whole. Callers must hold the write lock.
+func (s *Store) compactWAL() {
+	start := time.Now()
+	ids := make([]int, 0, len(s.items))
+	for id := range s.items {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.Encode(walEntry{Op: walReset, NextID: s.nextID})
+	for _, id := range ids {
+		enc.Encode(walEntry{Op: walPut, Item: s.items[id], NextID: s.nextID})
+	}
+
+	f, err := replaceFile(s.wal.path, buf.Bytes())
+	if err != nil {
+		log.Printf("Compacting WAL %s: %v", s.wal.path, err)
+		return
+	}
+	s.wal.f.Close()
+	s.wal.f = f
+	s.wal.entries = len(ids) + 1
+	slog.Debug("Compacted WAL", "path", s.wal.path, "items", len(ids), "duration", time.Since(start))
+}
+
+// replaceFile writes data to a temporary file beside path, syncs it and
+// renames it over path, returning it open for appending. A crash leaves either
+// the old file or the new one, never a mix.
+func replaceFile(path string, data []byte) (*os.File, error) {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return nil, fmt.Errorf("replacing %s: %w", path, err)
+	}
+	return f, nil
+}
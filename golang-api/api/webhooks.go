@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookAttempts is how many times a delivery is tried before it is
+	// given up on, waiting webhookBackoff after the first failure and twice
+	// as long after each one since.
+	webhookAttempts = 4
+	webhookBackoff  = time.Second
+	// webhookQueue is how many events can wait for delivery; past it, new
+	// events are dropped rather than holding up the writes that publish them.
+	webhookQueue = 256
+	// webhookConcurrency caps deliveries in flight, retries included.
+	webhookConcurrency = 16
+)
+
+// webhookEvents are the event kinds a subscription can ask for, the same ones
+// GET /items/events streams.
+var webhookEvents = []string{"created", "updated", "bulk-updated", "bulk-tagged", "deleted", "reset"}
+
+var errUnknownEvent = errors.New("unknown event")
+
+// webhookSubscription is where to post events and which ones; no events
+// means all of them.
+type webhookSubscription struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (s *webhookSubscription) wants(kind string) bool {
+	return len(s.Events) == 0 || slices.Contains(s.Events, kind)
+}
+
+// webhookRegistry holds the webhook subscriptions and delivers item events to
+// them. Deliveries go through an import-url client, so they get the same
+// checks against non-public addresses and a circuit breaker of their own. With
+// a path set, subscriptions are saved to that file on every change and loaded
+// from it on startup.
+type webhookRegistry struct {
+	path   string
+	sender *urlImporter
+	logger *log.Logger
+	now    func() time.Time
+
+	mu     sync.Mutex
+	subs   []*webhookSubscription // in ID order
+	nextID int
+
+	queue chan itemEvent
+}
+
+func newWebhookRegistry(path string, sender *urlImporter, logger *log.Logger, now func() time.Time) (*webhookRegistry, error) {
+	w := &webhookRegistry{path: path, sender: sender, logger: logger, now: now, nextID: 1, queue: make(chan itemEvent, webhookQueue)}
+	if path == "" {
+		return w, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &w.subs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, sub := range w.subs {
+		w.nextID = max(w.nextID, sub.ID+1)
+	}
+	return w, nil
+}
+
+// add registers a subscription. Its URL must already have passed the
+// sender's checkURL.
+func (w *webhookRegistry) add(u *url.URL, events []string) (webhookSubscription, error) {
+	for _, event := range events {
+		if !slices.Contains(webhookEvents, event) {
+			return webhookSubscription{}, fmt.Errorf("%w %q: must be one of %s", errUnknownEvent, event, strings.Join(webhookEvents, ", "))
+		}
+	}
+	events = slices.Compact(slices.Sorted(slices.Values(events)))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	sub := &webhookSubscription{ID: w.nextID, URL: u.String(), Events: events, CreatedAt: w.now()}
+	w.subs = append(w.subs, sub)
+	if err := w.save(); err != nil {
+		w.subs = w.subs[:len(w.subs)-1]
+		return webhookSubscription{}, err
+	}
+	w.nextID++
+	return *sub, nil
+}
+
+func (w *webhookRegistry) list() []webhookSubscription {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	subs := make([]webhookSubscription, len(w.subs))
+	for i, sub := range w.subs {
+		subs[i] = *sub
+	}
+	return subs
+}
+
+// remove deletes a subscription, reporting whether there was one. Deliveries
+// already under way still finish.
+func (w *webhookRegistry) remove(id int) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	i := slices.IndexFunc(w.subs, func(sub *webhookSubscription) bool { return sub.ID == id })
+	if i < 0 {
+		return false, nil
+	}
+	removed := w.subs[i]
+	w.subs = slices.Delete(w.subs, i, i+1)
+	if err := w.save(); err != nil {
+		w.subs = slices.Insert(w.subs, i, removed)
+		return false, err
+	}
+	return true, nil
+}
+
+// save writes the subscriptions to path, if there is one. Callers must hold
+// mu.
+func (w *webhookRegistry) save() error {
+	if w.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(w.subs)
+	if err != nil {
+		return err
+	}
+	f, err := replaceFile(w.path, data)
+	if err != nil {
+		return fmt.Errorf("saving webhooks: %w", err)
+	}
+	return f.Close()
+}
+
+// enqueue queues an event for the subscriptions that want it. It never
+// blocks; when the queue is full the event is dropped and logged.
+func (w *webhookRegistry) enqueue(event itemEvent) {
+	select {
+	case w.queue <- event:
+	default:
+		w.logger.Printf("Dropped %s webhook event: %d events already waiting", event.kind, webhookQueue)
+	}
+}
+
+// run delivers queued events until ctx is done.
+func (w *webhookRegistry) run(ctx context.Context) {
+	slots := make(chan struct{}, webhookConcurrency)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-w.queue:
+			w.mu.Lock()
+			var subs []webhookSubscription
+			for _, sub := range w.subs {
+				if sub.wants(event.kind) {
+					subs = append(subs, *sub)
+				}
+			}
+			w.mu.Unlock()
+
+			for _, sub := range subs {
+				select {
+				case slots <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				go func() {
+					defer func() { <-slots }()
+					w.deliver(ctx, sub, event)
+				}()
+			}
+		}
+	}
+}
+
+// deliver posts an event to one subscription, retrying with backoff on
+// network errors and on responses other than 2xx. It gives up at once while
+// the circuit for the subscription's host is open.
+func (w *webhookRegistry) deliver(ctx context.Context, sub webhookSubscription, event itemEvent) {
+	body, err := json.Marshal(map[string]any{
+		"event":        event.kind,
+		"subscription": sub.ID,
+		"data":         json.RawMessage(event.data),
+	})
+	if err != nil {
+		w.logger.Printf("Encoding %s webhook event: %v", event.kind, err)
+		return
+	}
+
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err := w.post(ctx, sub.URL, body)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts || errors.Is(err, errCircuitOpen) {
+			w.logger.Printf("Giving up on %s webhook %d after %d attempts: %v", event.kind, sub.ID, attempt, err)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *webhookRegistry) post(ctx context.Context, target string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.sender.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (a *App) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if !decodeValidated(w, r, a.schemas["webhook-create"], &req) {
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err == nil {
+		err = a.webhooks.sender.checkURL(u)
+	}
+	if err != nil {
+		http.Error(w, "url must be an absolute https URL", http.StatusBadRequest)
+		return
+	}
+
+	sub, err := a.webhooks.add(u, req.Events)
+	switch {
+	case errors.Is(err, errUnknownEvent):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		LoggerFrom(r.Context()).Error("adding webhook", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/webhooks/%d", sub.ID))
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+func (a *App) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.webhooks.list())
+}
+
+func (a *App) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	removed, err := a.webhooks.remove(id)
+	if err != nil {
+		LoggerFrom(r.Context()).Error("removing webhook", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
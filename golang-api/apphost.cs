@@ -9,6 +9,7 @@ builder.AddDockerComposeEnvironment("env")
 // Add Go API with in-memory storage
 builder.AddGoApp("api", "./api")
     .WithHttpEndpoint(env: "PORT")
+    .WithHttpEndpoint(name: "grpc", env: "GRPC_PORT")
     .WithHttpHealthCheck("/health")
     .WithExternalHttpEndpoints();
 
@@ -22,7 +23,7 @@ public static class GoExtensions
         public IResourceBuilder<GolangAppResource> AddGoApp(
             string name,
             string appDirectory,
-            string entryPoint = "main.go")
+            string entryPoint = ".")
         {
             var golangAppResource = new GolangAppResource(name, appDirectory);
 